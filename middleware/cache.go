@@ -0,0 +1,254 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GreatSir/realclouds_go/models"
+	"github.com/gomodule/redigo/redis"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	//MODEL_CACHE_PREFIX *
+	MODEL_CACHE_PREFIX = "model"
+
+	//DEFAULT_MODEL_CACHE_TTL 默认缓存时间(秒)
+	DEFAULT_MODEL_CACHE_TTL = 600
+
+	//DEFAULT_MODEL_CACHE_NIL_TTL 默认不存在记录的缓存时间(秒)
+	DEFAULT_MODEL_CACHE_NIL_TTL = 60
+
+	//MODEL_CACHE_VERSION_TTL 版本号保留时间(秒), 应大于一次数据库读取的最长耗时
+	MODEL_CACHE_VERSION_TTL = 86400
+
+	//MODEL_CACHE_INVALIDATE_DELAY 未通过 models.Transaction 开启的事务中写入时, 延迟再次失效的时间
+	MODEL_CACHE_INVALIDATE_DELAY = 2 * time.Second
+
+	modelCacheNilValue = "-"
+	modelCacheIDsKey   = "cache:ids"
+)
+
+var (
+	modelCacheTables = make(map[string]*Redis)
+	modelCacheMutex  sync.RWMutex
+
+	//版本号未变化时才写入缓存, 避免读取期间发生的失效被旧数据覆盖
+	modelCacheSetScript = redis.NewScript(2, `if (redis.call("GET", KEYS[2]) or "") == ARGV[1] then return redis.call("SET", KEYS[1], ARGV[2], "EX", ARGV[3]) else return 0 end`)
+	//失效时递增版本号并删除缓存
+	modelCacheInvalidateScript = redis.NewScript(2, `redis.call("INCR", KEYS[2]) redis.call("EXPIRE", KEYS[2], ARGV[1]) return redis.call("DEL", KEYS[1])`)
+)
+
+//ModelCache Redis 读穿缓存, 按表名和 ID 缓存, 通过 gorm 回调在 create/update/delete 提交后失效
+//在 models.Transaction 中写入时提交后失效; raw SQL 等绕过 gorm 回调的写入需调用 Invalidate
+type ModelCache[T any, PT models.Entity[T]] struct {
+	Repo   *models.Repository[T, PT]
+	Redis  *Redis
+	TTL    int
+	NilTTL int
+	table  string
+	group  singleflight.Group
+}
+
+//NewModelCache *
+func NewModelCache[T any, PT models.Entity[T]](db *gorm.DB, r *Redis) *ModelCache[T, PT] {
+	table := db.NewScope(PT(new(T))).TableName()
+
+	modelCacheMutex.Lock()
+	modelCacheTables[table] = r
+	modelCacheMutex.Unlock()
+
+	callback := db.Callback()
+	if nil == callback.Create().Get("cache:invalidate") {
+		callback.Update().Before("gorm:update").Register("cache:collect", collectModelCacheCallback)
+		callback.Delete().Before("gorm:delete").Register("cache:collect", collectModelCacheCallback)
+		callback.Create().After("gorm:commit_or_rollback_transaction").Register("cache:invalidate", invalidateModelCacheCallback)
+		callback.Update().After("gorm:commit_or_rollback_transaction").Register("cache:invalidate", invalidateModelCacheCallback)
+		callback.Delete().After("gorm:commit_or_rollback_transaction").Register("cache:invalidate", invalidateModelCacheCallback)
+	}
+
+	return &ModelCache[T, PT]{
+		Repo:   models.NewRepository[T, PT](db),
+		Redis:  r,
+		TTL:    DEFAULT_MODEL_CACHE_TTL,
+		NilTTL: DEFAULT_MODEL_CACHE_NIL_TTL,
+		table:  table,
+	}
+}
+
+//ModelCacheKey *
+func ModelCacheKey(table, id string) string {
+	return fmt.Sprintf("%s:%s:%s", MODEL_CACHE_PREFIX, table, strings.TrimSpace(id))
+}
+
+func modelCacheVersionKey(key string) string {
+	return key + ":version"
+}
+
+//InvalidateModelCache 删除表 table 中 ids 的缓存, 表未注册缓存时忽略
+func InvalidateModelCache(table string, ids ...string) error {
+	modelCacheMutex.RLock()
+	r, ok := modelCacheTables[table]
+	modelCacheMutex.RUnlock()
+	if !ok {
+		return nil
+	}
+	return invalidateModelCache(r, table, ids...)
+}
+
+//Get 读穿缓存, 记录不存在时返回 gorm.ErrRecordNotFound
+func (m *ModelCache[T, PT]) Get(id string) (PT, error) {
+	key := ModelCacheKey(m.table, id)
+
+	data, err := m.Redis.GetBytes(key)
+	if nil == err {
+		return m.decode(data)
+	}
+	if redis.ErrNil != err {
+		log.Errorf("Model cache get error: %v", err)
+	}
+
+	val, err, _ := m.group.Do(key, func() (interface{}, error) {
+		return m.load(key, id)
+	})
+	if nil != err {
+		return nil, err
+	}
+	return m.decode(val.([]byte))
+}
+
+//Invalidate 删除缓存, 用于 raw SQL 等绕过 gorm 回调的写入
+func (m *ModelCache[T, PT]) Invalidate(ids ...string) error {
+	return invalidateModelCache(m.Redis, m.table, ids...)
+}
+
+func (m *ModelCache[T, PT]) load(key, id string) ([]byte, error) {
+	version, verr := m.Redis.GetString(modelCacheVersionKey(key))
+	if redis.ErrNil == verr {
+		verr = nil
+	}
+	if nil != verr {
+		log.Errorf("Model cache get version error: %v", verr)
+	}
+
+	data, err := m.Repo.Get(id)
+	if gorm.IsRecordNotFoundError(err) {
+		if nil == verr {
+			m.set(key, version, m.NilTTL, []byte(modelCacheNilValue))
+		}
+		return []byte(modelCacheNilValue), nil
+	}
+	if nil != err {
+		return nil, err
+	}
+
+	b, err := json.Marshal(data)
+	if nil != err {
+		return nil, err
+	}
+
+	if nil == verr {
+		m.set(key, version, m.TTL, b)
+	}
+	return b, nil
+}
+
+//set 版本号未变化时写入缓存
+func (m *ModelCache[T, PT]) set(key, version string, ttl int, b []byte) {
+	conn := m.Redis.RedisPool.Get()
+	defer conn.Close()
+	if _, err := modelCacheSetScript.Do(conn, key, modelCacheVersionKey(key), version, b, ttl); nil != err {
+		log.Errorf("Model cache set error: %v", err)
+	}
+}
+
+func (m *ModelCache[T, PT]) decode(b []byte) (PT, error) {
+	if string(b) == modelCacheNilValue {
+		return nil, gorm.ErrRecordNotFound
+	}
+	data := PT(new(T))
+	if err := json.Unmarshal(b, data); nil != err {
+		return nil, err
+	}
+	return data, nil
+}
+
+func invalidateModelCache(r *Redis, table string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	conn := r.RedisPool.Get()
+	defer conn.Close()
+
+	for _, id := range ids {
+		key := ModelCacheKey(table, id)
+		if _, err := modelCacheInvalidateScript.Do(conn, key, modelCacheVersionKey(key), MODEL_CACHE_VERSION_TTL); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+func modelCacheRedis(scope *gorm.Scope) (*Redis, bool) {
+	modelCacheMutex.RLock()
+	defer modelCacheMutex.RUnlock()
+	r, ok := modelCacheTables[scope.TableName()]
+	return r, ok
+}
+
+//collectModelCacheCallback 按条件批量更新/删除时, 记录受影响的 ID
+func collectModelCacheCallback(scope *gorm.Scope) {
+	if scope.HasError() || !scope.PrimaryKeyZero() {
+		return
+	}
+	if _, ok := modelCacheRedis(scope); !ok {
+		return
+	}
+
+	ids := make([]string, 0)
+	if err := scope.DB().Pluck(scope.PrimaryKey(), &ids).Error; nil != err {
+		scope.Err(err)
+		return
+	}
+	scope.InstanceSet(modelCacheIDsKey, ids)
+}
+
+func invalidateModelCacheCallback(scope *gorm.Scope) {
+	if scope.HasError() {
+		return
+	}
+
+	r, ok := modelCacheRedis(scope)
+	if !ok {
+		return
+	}
+
+	var ids []string
+	if !scope.PrimaryKeyZero() {
+		ids = []string{fmt.Sprint(scope.PrimaryKeyValue())}
+	} else if v, ok := scope.InstanceGet(modelCacheIDsKey); ok {
+		ids, _ = v.([]string)
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	table := scope.TableName()
+	invalidate := func() {
+		if err := invalidateModelCache(r, table, ids...); nil != err {
+			log.Errorf("Model cache invalidate error: %v", err)
+		}
+	}
+
+	if !models.AfterCommit(scope.SQLDB(), invalidate) {
+		//无法得知提交时间, 立即失效并延迟再次失效
+		invalidate()
+		time.AfterFunc(MODEL_CACHE_INVALIDATE_DELAY, invalidate)
+	}
+}
@@ -1,16 +1,17 @@
 package middleware
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strings"
-	"unicode/utf8"
 
 	session "github.com/ipfans/echo-session"
 	"github.com/jinzhu/gorm"
 	"github.com/labstack/echo"
+	"github.com/GreatSir/realclouds_go/models"
 	"github.com/GreatSir/realclouds_go/utils"
 )
 
@@ -36,6 +37,19 @@ func (c *Context) GetSession(key string) interface{} {
 	return val
 }
 
+//SessionUser 获取 Session 中的用户 ID, 不存在时返回 401
+func (c *Context) SessionUser(key string) (string, error) {
+	val := c.GetSession(key)
+	if nil == val {
+		return "", echo.ErrUnauthorized
+	}
+	user := strings.TrimSpace(utils.ToStr(val))
+	if len(user) == 0 {
+		return "", echo.ErrUnauthorized
+	}
+	return user, nil
+}
+
 //SetSession 添加Session
 func (c *Context) SetSession(key string, val interface{}) error {
 	session := session.Default(c.Context)
@@ -92,6 +106,19 @@ func (c *Context) ToString(val string) error {
 	return c.String(http.StatusOK, val)
 }
 
+//ToError 转换数据错误: 乐观锁冲突 409, 记录不存在 404
+func (c *Context) ToError(err error) error {
+	switch {
+	case nil == err:
+		return nil
+	case models.IsConflict(err):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case gorm.IsRecordNotFoundError(err):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	return err
+}
+
 //JSONBind 绑定JSON
 func (c *Context) JSONBind(val interface{}) error {
 	body := c.Request().Body
@@ -126,6 +153,35 @@ func (c *Context) MySQL() *gorm.DB {
 	return c.Get("mysql").(*gorm.DB)
 }
 
+//AuditMySQL 获取带审计信息的 MySQL driver, 操作人取自 Session 中的 actorKey
+func (c *Context) AuditMySQL(actorKey string) *gorm.DB {
+	actor := ""
+	if val := c.GetSession(actorKey); nil != val {
+		actor = utils.ToStr(val)
+	}
+
+	return models.WithAuditor(c.MySQL(), actor, c.RequestID())
+}
+
+//RequestID 请求头或响应头中的 X-Request-ID
+func (c *Context) RequestID() string {
+	requestID := c.Request().Header.Get(echo.HeaderXRequestID)
+	if len(requestID) == 0 {
+		requestID = c.Response().Header().Get(echo.HeaderXRequestID)
+	}
+	return requestID
+}
+
+//KafkaContext 带有请求 ID 及 trace context 的 context, 传给 SyncSend/ASyncSend 后写入消息 headers
+//写入 headers 需要 KafkaConfig.Version >= sarama.V0_11_0_0, 默认版本 V0_9_0_1 时不传递
+func (c *Context) KafkaContext() context.Context {
+	return WithKafkaTrace(c.Request().Context(), KafkaTrace{
+		RequestID:   c.RequestID(),
+		TraceParent: c.Request().Header.Get(KAFKA_HEADER_TRACEPARENT),
+		TraceState:  c.Request().Header.Get(KAFKA_HEADER_TRACESTATE),
+	})
+}
+
 //Redis 获取 Redis pool
 func (c *Context) Redis() *Redis {
 	return c.Get("redis").(*Redis)
@@ -136,9 +192,20 @@ func (c *Context) SMS() *SMS {
 	return c.Get("sms").(*SMS)
 }
 
-//Kafka 获取 Kafka
+//Kafka 获取 Kafka, 挂载的不是 *Kafka (如 MemKafka) 时 panic
+//
+//Deprecated: 使用 KafkaClient, 测试时可替换为 MemKafka
 func (c *Context) Kafka() *Kafka {
-	return c.Get("kafka").(*Kafka)
+	k, ok := c.Get("kafka").(*Kafka)
+	if !ok {
+		panic(fmt.Sprintf("Context.Kafka: %T is not *Kafka, use KafkaClient", c.Get("kafka")))
+	}
+	return k
+}
+
+//KafkaClient 获取 KafkaClient, 可能是 *Kafka 或测试用的 *MemKafka
+func (c *Context) KafkaClient() KafkaClient {
+	return c.Get("kafka").(KafkaClient)
 }
 
 //DrityWord 获取 Drity word
@@ -154,24 +221,7 @@ func (c *Context) UpdateDrityWord(drityWordMap map[string]string) error {
 
 //DrityWordFilter *
 func (c *Context) DrityWordFilter(source string) string {
-	source = strings.TrimSpace(source)
-
-	var buf bytes.Buffer
-
-	segments := c.DrityWord().Segmenter.Segment([]byte(source))
-
-	dwm := *c.DrityWord().DrityWordMap
-	for _, seg := range segments {
-		md5Word := utils.StringUtils(seg.Token().Text()).MD5()
-		_, ok := dwm[md5Word]
-		if ok {
-			buf.WriteString(strings.Repeat("*", utf8.RuneCountInString(seg.Token().Text())))
-		} else {
-			buf.WriteString(seg.Token().Text())
-		}
-	}
-
-	return buf.String()
+	return c.DrityWord().Filter(source)
 }
 
 //NewCtx 获取 WebContext
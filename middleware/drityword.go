@@ -8,6 +8,7 @@ import (
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	log "github.com/sirupsen/logrus"
 
@@ -44,6 +45,28 @@ func (d *DrityWord) MwDrityWord(next echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
+//Filter 将敏感词替换为等长的 *
+func (d *DrityWord) Filter(source string) string {
+	source = strings.TrimSpace(source)
+
+	var buf bytes.Buffer
+
+	segments := d.Segmenter.Segment([]byte(source))
+
+	dwm := *d.DrityWordMap
+	for _, seg := range segments {
+		md5Word := utils.StringUtils(seg.Token().Text()).MD5()
+		_, ok := dwm[md5Word]
+		if ok {
+			buf.WriteString(strings.Repeat("*", utf8.RuneCountInString(seg.Token().Text())))
+		} else {
+			buf.WriteString(seg.Token().Text())
+		}
+	}
+
+	return buf.String()
+}
+
 //NewDrityWord *
 func NewDrityWord(db *gorm.DB, userDictPath ...string) (drityWord *DrityWord, err error) {
 	userDict := USER_DICT_PATH
@@ -64,7 +87,10 @@ func NewDrityWord(db *gorm.DB, userDictPath ...string) (drityWord *DrityWord, er
 		Gorm:           db,
 	}
 
-	_, drityWords := FindDrityWords(db)
+	_, drityWords, err := FindDrityWords(db)
+	if nil != err {
+		return nil, err
+	}
 
 	drityWordMap := make(map[string]string)
 
@@ -147,7 +173,12 @@ func (d *DrityWord) Subscription(rPool *redis.Pool) error {
 				log.Debugf("channel: %s, message: %v\n", channel, msgStr)
 
 				if DRITYWORD_UP_SUBSCRIPTION_KEY == channel {
-					_, drityWords := FindDrityWords(d.Gorm)
+					_, drityWords, err := FindDrityWords(d.Gorm)
+					if nil != err {
+						cancel()
+						log.Errorf("\nDrity word subscription error: %v\n", err.Error())
+						return err
+					}
 
 					drityWordMap := make(map[string]string)
 
@@ -4,6 +4,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/GreatSir/realclouds_go/models"
 	"github.com/jinzhu/gorm"
 	"github.com/pborman/uuid"
 )
@@ -59,46 +60,69 @@ func FindDrityWordByMD5(db *gorm.DB, md5 string) (data DrityWordDB, boo bool) {
 	return
 }
 
-//UpdateDrityWord *
+//UpdateDrityWord 乐观锁更新, cas_column 不一致时返回 *models.ConflictError
 func UpdateDrityWord(db *gorm.DB, data *DrityWordDB) (err error) {
-	return db.Model(&DrityWordDB{}).Update(&data).Error
+	return models.UpdateCAS(db, data, &data.MemcachedCasColumn, nil)
 }
 
-//FindDrityWords *
-func FindDrityWords(db *gorm.DB, args ...string) (count int, data []DrityWordDB) {
-	argMap := paramsToMaps(args)
-
-	ids, _ := argMap["ids"]
-	md5s, _ := argMap["md5s"]
-	keywords, _ := argMap["keywords"]
+//drityWordFilters FindDrityWords 支持的参数
+var drityWordFilters = map[string]func(val string) models.Filter{
+	"ids": func(val string) models.Filter {
+		return DrityWordIDs(strings.Split(val, ",")...)
+	},
+	"md5s": func(val string) models.Filter {
+		return DrityWordMD5s(strings.Split(val, ",")...)
+	},
+	"keywords": DrityWordKeywords,
+}
 
-	if len(ids) != 0 {
-		dwIDs := strings.Split(ids, ",")
-		idsLen := len(dwIDs)
-		if idsLen > 0 {
-			db = db.Where("id in (?)", dwIDs)
-		}
+//FindDrityWords 参数: ids, md5s (逗号分隔), keywords; 未知参数或参数个数为奇数时返回错误
+func FindDrityWords(db *gorm.DB, args ...string) (count int, data []DrityWordDB, err error) {
+	filter, err := models.ParseFilters(args, drityWordFilters)
+	if nil != err {
+		return
 	}
+	return FindDrityWordsBy(db, filter)
+}
 
-	if len(md5s) != 0 {
-		dwMD5s := strings.Split(md5s, ",")
-		idsLen := len(dwMD5s)
-		if idsLen > 0 {
-			db = db.Where("md5 in (?)", dwMD5s)
-		}
+//FindDrityWordsBy 按条件查询, 条件无效时返回错误
+func FindDrityWordsBy(db *gorm.DB, filters ...models.Filter) (count int, data []DrityWordDB, err error) {
+	sql, vals, err := models.And(filters...).Build()
+	if nil != err {
+		return
 	}
-
-	if len(keywords) != 0 {
-		if len(keywords) != 0 {
-			db = db.Where("name LIKE ?", "%"+keywords+"%").Or("description LIKE ?", "%"+keywords+"%").Or("value LIKE ?", "%"+keywords+"%").Or("md5 LIKE ?", "%"+keywords+"%")
-		}
+	if len(sql) != 0 {
+		db = db.Where(sql, vals...)
 	}
 
-	db.Model(&DrityWordDB{}).Count(&count).Find(&data)
-
+	err = db.Model(&DrityWordDB{}).Count(&count).Find(&data).Error
 	return
 }
 
+//DrityWordIDs 按 ID 查询
+func DrityWordIDs(ids ...string) models.Filter {
+	return models.In("id", ids)
+}
+
+//DrityWordMD5s 按 MD5 查询
+func DrityWordMD5s(md5s ...string) models.Filter {
+	return models.In("md5", md5s)
+}
+
+//DrityWordKeywords 按关键字模糊查询 name/description/value/md5
+func DrityWordKeywords(keywords string) models.Filter {
+	keywords = strings.TrimSpace(keywords)
+	if len(keywords) == 0 {
+		return nil
+	}
+	return models.Or(
+		models.Like("name", keywords),
+		models.Like("description", keywords),
+		models.Like("value", keywords),
+		models.Like("md5", keywords),
+	)
+}
+
 // DeleteDrityWordByID *
 func DeleteDrityWordByID(db *gorm.DB, id string) (err error) {
 	err = db.Where(&DrityWordDB{
@@ -106,17 +130,3 @@ func DeleteDrityWordByID(db *gorm.DB, id string) (err error) {
 	}).Delete(&DrityWordDB{}).Error
 	return
 }
-
-//paramsToMaps *
-func paramsToMaps(args []string) map[string]string {
-
-	params := make(map[string]string)
-
-	for i := 0; i < len(args); i = i + 2 {
-		key := args[i]
-		val := args[i+1]
-		params[key] = val
-	}
-
-	return params
-}
@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"testing"
+
+	"github.com/GreatSir/realclouds_go/models"
+	"github.com/GreatSir/realclouds_go/utils"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+func openTestDB(t *testing.T, values ...interface{}) *gorm.DB {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if nil != err {
+		t.Fatalf("gorm.Open() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.AutoMigrate(values...).Error; nil != err {
+		t.Fatalf("AutoMigrate() error = %v", err)
+	}
+	return db
+}
+
+func TestUpdateDrityWord(t *testing.T) {
+	db := openTestDB(t, &DrityWordDB{})
+	if err := models.RegisterAudit(db, &DrityWordDB{}); nil != err {
+		t.Fatalf("RegisterAudit() error = %v", err)
+	}
+
+	data := &DrityWordDB{Name: "word", Value: "foo", MD5: utils.StringUtils("foo").MD5()}
+	if err := AddDrityWord(db, data); nil != err {
+		t.Fatalf("AddDrityWord() error = %v", err)
+	}
+
+	//修改 Value 时 md5 随之变化, 只按 id 定位记录
+	data.Value, data.MD5 = "bar", utils.StringUtils("bar").MD5()
+	if err := UpdateDrityWord(db, data); nil != err {
+		t.Fatalf("UpdateDrityWord() error = %v", err)
+	}
+	if data.MemcachedCasColumn != 1 {
+		t.Errorf("cas = %d, want 1", data.MemcachedCasColumn)
+	}
+
+	saved, notFound := FindDrityWordByID(db, data.ID)
+	if notFound || saved.Value != "bar" || saved.MD5 != utils.StringUtils("bar").MD5() || saved.MemcachedCasColumn != 1 {
+		t.Fatalf("FindDrityWordByID() = %+v, %v", saved, notFound)
+	}
+
+	stale := saved
+	stale.MemcachedCasColumn = 0
+	stale.Value = "baz"
+	if err := UpdateDrityWord(db, &stale); !models.IsConflict(err) {
+		t.Errorf("UpdateDrityWord() stale cas error = %v, want conflict", err)
+	}
+
+	missing := DrityWordDB{ID: "missing", Value: "baz"}
+	if err := UpdateDrityWord(db, &missing); !gorm.IsRecordNotFoundError(err) {
+		t.Errorf("UpdateDrityWord() missing error = %v, want record not found", err)
+	}
+
+	//旧数据 cas 为 NULL
+	if err := db.Exec("UPDATE sys_drityword SET cas_column = NULL WHERE id = ?", data.ID).Error; nil != err {
+		t.Fatal(err)
+	}
+	legacy := DrityWordDB{ID: data.ID, Value: "qux", MD5: utils.StringUtils("qux").MD5()}
+	if err := UpdateDrityWord(db, &legacy); nil != err {
+		t.Fatalf("UpdateDrityWord() NULL cas error = %v", err)
+	}
+	if saved, _ = FindDrityWordByID(db, data.ID); saved.Value != "qux" || saved.MemcachedCasColumn != 1 {
+		t.Errorf("FindDrityWordByID() after NULL cas = %+v", saved)
+	}
+
+	count := 0
+	if err := db.Model(&models.AuditLog{}).Where("entity_id = ? AND action = ?", data.ID, models.AuditUpdate).Count(&count).Error; nil != err || count != 2 {
+		t.Errorf("update audit logs = %d, %v, want 2", count, err)
+	}
+}
+
+func TestFindDrityWords(t *testing.T) {
+	db := openTestDB(t, &DrityWordDB{})
+
+	ids := make([]string, 0)
+	for _, value := range []string{"foo", "bar", "baz"} {
+		data := &DrityWordDB{Name: value, Value: value, MD5: utils.StringUtils(value).MD5()}
+		if err := AddDrityWord(db, data); nil != err {
+			t.Fatal(err)
+		}
+		ids = append(ids, data.ID)
+	}
+
+	tests := []struct {
+		name  string
+		args  []string
+		count int
+		err   bool
+	}{
+		{"all", nil, 3, false},
+		{"ids", []string{"ids", ids[0] + "," + ids[1]}, 2, false},
+		{"md5s", []string{"md5s", utils.StringUtils("baz").MD5()}, 1, false},
+		{"keywords", []string{"keywords", "ba"}, 2, false},
+		{"empty value", []string{"ids", " "}, 3, false},
+		{"combined", []string{"ids", ids[0] + "," + ids[1], "keywords", "ba"}, 1, false},
+		{"unknown", []string{"name", "foo"}, 0, true},
+		{"odd", []string{"ids"}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			count, data, err := FindDrityWords(db, tt.args...)
+			if tt.err {
+				if nil == err {
+					t.Fatalf("FindDrityWords() error = nil, want error")
+				}
+				return
+			}
+			if nil != err || count != tt.count || len(data) != tt.count {
+				t.Errorf("FindDrityWords() = %d, %d, %v, want %d", count, len(data), err, tt.count)
+			}
+		})
+	}
+}
@@ -0,0 +1,412 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GreatSir/realclouds_go/models"
+	"github.com/gomodule/redigo/redis"
+	"github.com/jinzhu/gorm"
+	"github.com/labstack/echo"
+	"github.com/pborman/uuid"
+)
+
+const (
+	//INBOX_UNREAD_PREFIX 未读数 key 前缀, 后接接收者
+	INBOX_UNREAD_PREFIX = "inbox:unread:"
+	//INBOX_UNREAD_VERSION_PREFIX 未读数版本 key 前缀, 未读数未加载时的变更及全部已读会递增版本
+	INBOX_UNREAD_VERSION_PREFIX = "inbox:unread_version:"
+	//DEFAULT_INBOX_UNREAD_TTL 未读数缓存过期时间(秒), 过期后从 MySQL 重新统计
+	DEFAULT_INBOX_UNREAD_TTL = 600
+	//DEFAULT_INBOX_BATCH_SIZE 公共消息扇出时每个事务写入的接收者数量
+	DEFAULT_INBOX_BATCH_SIZE = 500
+)
+
+var (
+	//未读数 key 不存在时不创建, 递增版本使正在进行的 UnreadCount 放弃写入
+	inboxIncrScript = redis.NewScript(2, `if redis.call("EXISTS", KEYS[1]) == 1 then return redis.call("INCRBY", KEYS[1], ARGV[1]) end
+redis.call("INCR", KEYS[2]) redis.call("EXPIRE", KEYS[2], ARGV[2]) return false`)
+	//删除未读数并递增版本
+	inboxResetScript = redis.NewScript(2, `redis.call("INCR", KEYS[2]) redis.call("EXPIRE", KEYS[2], ARGV[1]) return redis.call("DEL", KEYS[1])`)
+	//版本未变化时写入 MySQL 统计的未读数, 返回当前缓存的未读数, 未写入时返回 nil
+	inboxSeedScript = redis.NewScript(2, `if (redis.call("GET", KEYS[2]) or "") == ARGV[1] then redis.call("SET", KEYS[1], ARGV[2], "EX", ARGV[3], "NX") end
+return redis.call("GET", KEYS[1])`)
+)
+
+//InboxMessage 收件箱消息, 每个接收者一条; 同一条 Kafka 消息重复消费时不会重复写入
+type InboxMessage struct {
+	ID        string     `sql:"index" gorm:"primary_key;column:id;type:varchar(100)" json:"id,omitempty" xml:"id,omitempty"`
+	Receiver  string     `gorm:"column:receiver;type:varchar(100);index:idx_inbox_receiver;unique_index:idx_inbox_source" json:"receiver,omitempty" xml:"receiver,omitempty"`
+	Topic     string     `gorm:"column:topic;type:varchar(255);unique_index:idx_inbox_source" json:"-" xml:"-"`
+	Partition int32      `gorm:"column:kafka_partition;type:int(11);unique_index:idx_inbox_source" json:"-" xml:"-"`
+	Offset    int64      `gorm:"column:kafka_offset;type:bigint(20);unique_index:idx_inbox_source" json:"-" xml:"-"`
+	Data      string     `gorm:"column:data;type:text" json:"data,omitempty" xml:"data,omitempty"`
+	CreatedAt time.Time  `sql:"index" gorm:"column:created_at;type:timestamp" json:"created_at,omitempty" xml:"created_at,omitempty"`
+	ReadAt    *time.Time `gorm:"column:read_at;type:timestamp NULL" json:"read_at,omitempty" xml:"read_at,omitempty"`
+	DeletedAt *time.Time `sql:"index" gorm:"column:deleted_at;type:timestamp NULL" json:"deleted_at,omitempty" xml:"deleted_at,omitempty"`
+
+	KafkaDuMessage
+}
+
+//TableName *
+func (InboxMessage) TableName() string {
+	return "sys_inbox_message"
+}
+
+//BeforeCreate ID处理
+func (d *InboxMessage) BeforeCreate(scope *gorm.Scope) error {
+	uuidStr := uuid.NewRandom().String()
+	if err := scope.SetColumn("ID", uuidStr); nil != err {
+		return err
+	}
+	return nil
+}
+
+//Inbox 收件箱: 消费 KafkaMsg 写入 MySQL, 未读数缓存在 Redis
+type Inbox struct {
+	Gorm  *gorm.DB
+	Redis *Redis
+	//SessionKey Session 中当前用户 ID 的 key
+	SessionKey string
+	//Receivers 公共消息(Type==1)未指定接收者时返回全部接收者
+	Receivers func(ctx context.Context, msg *KafkaMsg) ([]string, error)
+	BatchSize int
+	//UnreadTTL 未读数缓存过期时间(秒), 即缓存与 MySQL 不一致的最长时间
+	UnreadTTL int
+}
+
+//NewInbox *
+func NewInbox(db *gorm.DB, r *Redis, sessionKey string) (*Inbox, error) {
+	if err := db.AutoMigrate(&InboxMessage{}).Error; nil != err {
+		return nil, err
+	}
+
+	inbox := &Inbox{
+		Gorm:       db,
+		Redis:      r,
+		SessionKey: sessionKey,
+		BatchSize:  DEFAULT_INBOX_BATCH_SIZE,
+		UnreadTTL:  DEFAULT_INBOX_UNREAD_TTL,
+	}
+	return inbox, nil
+}
+
+//Consume 订阅 topics 并写入收件箱, 阻塞直到 ctx 取消
+func (i *Inbox) Consume(ctx context.Context, kafka KafkaClient, topics []string, group string, opts ...KafkaConsumerOpt) error {
+	return kafka.SubscribeMessage(ctx, topics, group, i.HandleMessage, opts...)
+}
+
+//HandleMessage 解码 KafkaMsg 并写入收件箱
+func (i *Inbox) HandleMessage(ctx context.Context, msg *KafkaMessage) error {
+	data, err := msg.Decode()
+	if nil != err {
+		return err
+	}
+	return i.Deliver(ctx, msg, &data)
+}
+
+//Deliver 为每个接收者写入一条消息, 公共消息未指定接收者时通过 Receivers 扇出
+//source 用于去重, 同一 topic/partition/offset 对同一接收者只写入一次
+func (i *Inbox) Deliver(ctx context.Context, source *KafkaMessage, msg *KafkaMsg) error {
+	receivers := msg.Receiver
+	if len(receivers) == 0 && msg.Message.Type == NotifyPublic {
+		if nil == i.Receivers {
+			return fmt.Errorf("%s", "Inbox receivers hook is nil.")
+		}
+		var err error
+		if receivers, err = i.Receivers(ctx, msg); nil != err {
+			return err
+		}
+	}
+
+	var data string
+	if nil != msg.Data {
+		b, err := json.Marshal(msg.Data)
+		if nil != err {
+			return err
+		}
+		data = string(b)
+	}
+
+	batchSize := i.BatchSize
+	if batchSize <= 0 {
+		batchSize = DEFAULT_INBOX_BATCH_SIZE
+	}
+
+	for start := 0; start < len(receivers); start += batchSize {
+		if err := ctx.Err(); nil != err {
+			return err
+		}
+
+		end := start + batchSize
+		if end > len(receivers) {
+			end = len(receivers)
+		}
+
+		created := make([]string, 0, end-start)
+		err := i.Gorm.Transaction(func(tx *gorm.DB) error {
+			tx = tx.Set("gorm:insert_modifier", "IGNORE")
+			for _, receiver := range receivers[start:end] {
+				receiver = strings.TrimSpace(receiver)
+				if len(receiver) == 0 {
+					continue
+				}
+
+				message := msg.Message
+				message.Read = false
+				row := &InboxMessage{
+					Receiver:       receiver,
+					Topic:          source.Topic,
+					Partition:      source.Partition,
+					Offset:         source.Offset,
+					Data:           data,
+					KafkaDuMessage: message,
+				}
+				result := tx.Create(row)
+				if nil != result.Error {
+					return result.Error
+				}
+				if result.RowsAffected > 0 {
+					created = append(created, receiver)
+				}
+			}
+			return nil
+		})
+		if nil != err {
+			return err
+		}
+
+		for _, receiver := range created {
+			i.incrUnread(receiver, 1)
+		}
+	}
+	return nil
+}
+
+//List 分页查询接收者的消息, 按创建时间倒序
+func (i *Inbox) List(receiver string, page models.Page, filters ...models.Filter) (*models.PageResult[InboxMessage], error) {
+	filters = append([]models.Filter{models.Eq("receiver", receiver)}, filters...)
+	sql, args, err := models.And(filters...).Build()
+	if nil != err {
+		return nil, err
+	}
+
+	db := i.Gorm.Model(&InboxMessage{}).Where(sql, args...)
+
+	var total int
+	if err := db.Count(&total).Error; nil != err {
+		return nil, err
+	}
+
+	data := make([]InboxMessage, 0)
+	if err := db.Order("created_at DESC").Order("id DESC").
+		Offset(page.Offset()).Limit(page.Size).Find(&data).Error; nil != err {
+		return nil, err
+	}
+
+	return models.NewPageResult(page, total, data), nil
+}
+
+//UnreadCount 未读数, Redis 中不存在时从 MySQL 统计后写入, 缓存 UnreadTTL 秒
+//统计期间有新消息或已读/删除时(版本变化)不写入缓存, 直接返回统计结果;
+//消息写入 MySQL 后, 递增未读数前恰好完成统计并写入缓存时会重复计数, 偏差最多持续 UnreadTTL 秒
+func (i *Inbox) UnreadCount(receiver string) (count int64, err error) {
+	key, versionKey := INBOX_UNREAD_PREFIX+receiver, INBOX_UNREAD_VERSION_PREFIX+receiver
+	if count, err = i.Redis.GetInt64(key); nil == err {
+		return
+	}
+
+	conn := i.Redis.RedisPool.Get()
+	defer conn.Close()
+
+	version, err := redis.String(conn.Do("GET", versionKey))
+	if nil != err && err != redis.ErrNil {
+		return
+	}
+
+	if err = i.unreadQuery(receiver).Count(&count).Error; nil != err {
+		return
+	}
+
+	cached, err := redis.Int64(inboxSeedScript.Do(conn, key, versionKey, version, count, i.unreadTTL()))
+	if err == redis.ErrNil {
+		return count, nil
+	}
+	if nil != err {
+		return
+	}
+	return cached, nil
+}
+
+//MarkRead 标记已读, 消息不存在时返回 gorm.ErrRecordNotFound
+func (i *Inbox) MarkRead(receiver, id string) error {
+	result := i.unreadQuery(receiver).Where("id = ?", strings.TrimSpace(id)).
+		UpdateColumns(map[string]interface{}{"read": true, "read_at": time.Now()})
+	if nil != result.Error {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return i.exists(receiver, id)
+	}
+	i.incrUnread(receiver, -result.RowsAffected)
+	return nil
+}
+
+//MarkAllRead 全部标记已读
+func (i *Inbox) MarkAllRead(receiver string) error {
+	err := i.unreadQuery(receiver).
+		UpdateColumns(map[string]interface{}{"read": true, "read_at": time.Now()}).Error
+	if nil != err {
+		return err
+	}
+	return i.resetUnread(receiver)
+}
+
+//Delete 删除消息(软删除), 消息不存在时返回 gorm.ErrRecordNotFound
+func (i *Inbox) Delete(receiver, id string) error {
+	data := &InboxMessage{}
+	err := i.Gorm.Where("receiver = ? AND id = ?", receiver, strings.TrimSpace(id)).First(data).Error
+	if nil != err {
+		return err
+	}
+
+	result := i.Gorm.Delete(data)
+	if nil != result.Error {
+		return result.Error
+	}
+	if !data.Read && result.RowsAffected > 0 {
+		i.incrUnread(receiver, -1)
+	}
+	return nil
+}
+
+//Routes 注册接口, 需要 MwContext 及 Session:
+//GET / 列表(?unread=true 仅未读, ?type_2= 类别), GET /unread 未读数,
+//PUT /read 全部已读, PUT /:id/read 已读, DELETE /:id 删除
+func (i *Inbox) Routes(g *echo.Group) {
+	g.GET("", i.listHandler)
+	g.GET("/unread", i.unreadHandler)
+	g.PUT("/read", i.markAllReadHandler)
+	g.PUT("/:id/read", i.markReadHandler)
+	g.DELETE("/:id", i.deleteHandler)
+}
+
+func (i *Inbox) listHandler(ec echo.Context) error {
+	c := NewCtx(ec)
+	receiver, err := c.SessionUser(i.SessionKey)
+	if nil != err {
+		return err
+	}
+
+	page, err := models.PageFromContext(c)
+	if nil != err {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	filters := make([]models.Filter, 0)
+	if unread, _ := strconv.ParseBool(c.QueryParam("unread")); unread {
+		filters = append(filters, models.Eq("sys_inbox_message.read", false))
+	}
+	if kind := strings.TrimSpace(c.QueryParam("type_2")); len(kind) != 0 {
+		filters = append(filters, models.Eq("type_2", kind))
+	}
+
+	data, err := i.List(receiver, page, filters...)
+	if nil != err {
+		return c.ToError(err)
+	}
+	return c.ToJSON(data)
+}
+
+func (i *Inbox) unreadHandler(ec echo.Context) error {
+	c := NewCtx(ec)
+	receiver, err := c.SessionUser(i.SessionKey)
+	if nil != err {
+		return err
+	}
+
+	count, err := i.UnreadCount(receiver)
+	if nil != err {
+		return c.ToError(err)
+	}
+	return c.ToJSON(map[string]int64{"unread": count})
+}
+
+func (i *Inbox) markReadHandler(ec echo.Context) error {
+	c := NewCtx(ec)
+	receiver, err := c.SessionUser(i.SessionKey)
+	if nil != err {
+		return err
+	}
+
+	if err := i.MarkRead(receiver, c.PathValue("id")); nil != err {
+		return c.ToError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (i *Inbox) markAllReadHandler(ec echo.Context) error {
+	c := NewCtx(ec)
+	receiver, err := c.SessionUser(i.SessionKey)
+	if nil != err {
+		return err
+	}
+
+	if err := i.MarkAllRead(receiver); nil != err {
+		return c.ToError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (i *Inbox) deleteHandler(ec echo.Context) error {
+	c := NewCtx(ec)
+	receiver, err := c.SessionUser(i.SessionKey)
+	if nil != err {
+		return err
+	}
+
+	if err := i.Delete(receiver, c.PathValue("id")); nil != err {
+		return c.ToError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (i *Inbox) unreadQuery(receiver string) *gorm.DB {
+	return i.Gorm.Model(&InboxMessage{}).Where("receiver = ? AND `read` = ?", receiver, false)
+}
+
+func (i *Inbox) exists(receiver, id string) error {
+	data := &InboxMessage{}
+	return i.Gorm.Select("id").Where("receiver = ? AND id = ?", receiver, strings.TrimSpace(id)).First(data).Error
+}
+
+func (i *Inbox) unreadTTL() int {
+	if i.UnreadTTL <= 0 {
+		return DEFAULT_INBOX_UNREAD_TTL
+	}
+	return i.UnreadTTL
+}
+
+//incrUnread 未读数已加载时增减, 未加载时只递增版本; 出错时删除 key, 下次从 MySQL 重新统计
+func (i *Inbox) incrUnread(receiver string, delta int64) {
+	key, versionKey := INBOX_UNREAD_PREFIX+receiver, INBOX_UNREAD_VERSION_PREFIX+receiver
+	conn := i.Redis.RedisPool.Get()
+	defer conn.Close()
+	if _, err := inboxIncrScript.Do(conn, key, versionKey, delta, i.unreadTTL()); nil != err {
+		inboxResetScript.Do(conn, key, versionKey, i.unreadTTL())
+	}
+}
+
+//resetUnread 删除未读数并递增版本, 下次从 MySQL 重新统计
+func (i *Inbox) resetUnread(receiver string) error {
+	conn := i.Redis.RedisPool.Get()
+	defer conn.Close()
+	_, err := inboxResetScript.Do(conn, INBOX_UNREAD_PREFIX+receiver, INBOX_UNREAD_VERSION_PREFIX+receiver, i.unreadTTL())
+	return err
+}
@@ -1,52 +1,48 @@
 package middleware
 
 import (
+	"context"
 	"encoding/json"
+	"sync"
+	"sync/atomic"
 
 	"github.com/Shopify/sarama"
-	cluster "github.com/bsm/sarama-cluster"
 
 	"github.com/labstack/echo"
-	"github.com/GreatSir/realclouds_go/utils"
 
-	"crypto/tls"
 	"fmt"
-	"strings"
-	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
 var (
-	//DefaultKafkaVersion *
+	//DefaultKafkaVersion 默认版本, 低于 0.11 时不发送 record headers, 需要 message-id 及 trace context 时应配置 Version >= V0_11_0_0
 	DefaultKafkaVersion = sarama.V0_9_0_1
 )
 
-//KafkaDuMessage *
+//KafkaDuMessage 通知消息, Type..Type4 取值见 NotifyScope/NotifyKind/NotifyResource/NotifyAction
+//优先使用 NewCommentNotify 等构造函数生成
 type KafkaDuMessage struct {
-	Type int `json:"type" xml:"type" structs:"type" gorm:"column:type"` //1-公共消息  2-私有消息
-	//1-评论 2-回复 3-点赞 4-关注 5-播客 6-下架 7-审核 8-金币 9-爱心值 10-推荐 11-活动 12-音频 13-文本 14-专辑
-	Type2 int `json:"type_2" xml:"type_2" structs:"type_2" gorm:"column:type_2"`
-	//0-通用 1-音频 2-文本 3-专辑 4-评论
-	Type3 int `json:"type_3" xml:"type_3" structs:"type_3" gorm:"column:type_3"`
-	//1-收到 2-发送 3-新增 4-更新 5-收入 6-支出 7-线上 8-线下 9-升级 10-降级 11-通过 12-驳回 13-审核中 14-首页 15-横幅
-	Type4       int    `json:"type_4" xml:"type_4" structs:"type_4" gorm:"column:type_4"`
-	Type5       int    `json:"type_5" xml:"type_5" structs:"type_5" gorm:"column:type_5"` //预留类型
-	Type6       int    `json:"type_6" xml:"type_6" structs:"type_6" gorm:"column:type_6"` //预留类型
-	ResourceID  string `json:"resource_id,omitempty" xml:"resource_id,omitempty" structs:"resource_id" gorm:"column:resource_id"`
-	ResourceID2 string `json:"resource_id_2,omitempty" xml:"resource_id_2,omitempty" structs:"resource_id_2" gorm:"column:resource_id_2"`
-	ResourceID3 string `json:"resource_id_3,omitempty" xml:"resource_id_3,omitempty" structs:"resource_id_3" gorm:"column:resource_id_3"`
-	ResourceID4 string `json:"resource_id_4,omitempty" xml:"resource_id_4,omitempty" structs:"resource_id_4" gorm:"column:resource_id_4"`
-	ResourceID5 string `json:"resource_id_5,omitempty" xml:"resource_id_5,omitempty" structs:"resource_id_5" gorm:"column:resource_id_5"`
-	ResourceID6 string `json:"resource_id_6,omitempty" xml:"resource_id_6,omitempty" structs:"resource_id_6" gorm:"column:resource_id_6"`
-	Value       string `json:"value,omitempty" xml:"value,omitempty" structs:"value" gorm:"column:value"`
-	Value2      string `json:"value_2,omitempty" xml:"value_2,omitempty" structs:"value_2" gorm:"column:value_2"`
-	Value3      string `json:"value_3,omitempty" xml:"value_3,omitempty" structs:"value_3" gorm:"column:value_3"`
-	Value4      string `json:"value_4,omitempty" xml:"value_4,omitempty" structs:"value_4" gorm:"column:value_4"`
-	Value5      string `json:"value_5,omitempty" xml:"value_5,omitempty" structs:"value_5" gorm:"column:value_5"`
-	Value6      string `json:"value_6,omitempty" xml:"value_6,omitempty" structs:"value_6" gorm:"column:value_6"`
-	Read        bool   `json:"read" xml:"read" structs:"read" gorm:"column:read"`
-	State       int    `json:"state" xml:"state" structs:"state" gorm:"column:state"`
+	Type        NotifyScope    `json:"type" xml:"type" structs:"type" gorm:"column:type"`
+	Type2       NotifyKind     `json:"type_2" xml:"type_2" structs:"type_2" gorm:"column:type_2"`
+	Type3       NotifyResource `json:"type_3" xml:"type_3" structs:"type_3" gorm:"column:type_3"`
+	Type4       NotifyAction   `json:"type_4" xml:"type_4" structs:"type_4" gorm:"column:type_4"`
+	Type5       int            `json:"type_5" xml:"type_5" structs:"type_5" gorm:"column:type_5"` //预留类型
+	Type6       int            `json:"type_6" xml:"type_6" structs:"type_6" gorm:"column:type_6"` //预留类型
+	ResourceID  string         `json:"resource_id,omitempty" xml:"resource_id,omitempty" structs:"resource_id" gorm:"column:resource_id"`
+	ResourceID2 string         `json:"resource_id_2,omitempty" xml:"resource_id_2,omitempty" structs:"resource_id_2" gorm:"column:resource_id_2"`
+	ResourceID3 string         `json:"resource_id_3,omitempty" xml:"resource_id_3,omitempty" structs:"resource_id_3" gorm:"column:resource_id_3"`
+	ResourceID4 string         `json:"resource_id_4,omitempty" xml:"resource_id_4,omitempty" structs:"resource_id_4" gorm:"column:resource_id_4"`
+	ResourceID5 string         `json:"resource_id_5,omitempty" xml:"resource_id_5,omitempty" structs:"resource_id_5" gorm:"column:resource_id_5"`
+	ResourceID6 string         `json:"resource_id_6,omitempty" xml:"resource_id_6,omitempty" structs:"resource_id_6" gorm:"column:resource_id_6"`
+	Value       string         `json:"value,omitempty" xml:"value,omitempty" structs:"value" gorm:"column:value"`
+	Value2      string         `json:"value_2,omitempty" xml:"value_2,omitempty" structs:"value_2" gorm:"column:value_2"`
+	Value3      string         `json:"value_3,omitempty" xml:"value_3,omitempty" structs:"value_3" gorm:"column:value_3"`
+	Value4      string         `json:"value_4,omitempty" xml:"value_4,omitempty" structs:"value_4" gorm:"column:value_4"`
+	Value5      string         `json:"value_5,omitempty" xml:"value_5,omitempty" structs:"value_5" gorm:"column:value_5"`
+	Value6      string         `json:"value_6,omitempty" xml:"value_6,omitempty" structs:"value_6" gorm:"column:value_6"`
+	Read        bool           `json:"read" xml:"read" structs:"read" gorm:"column:read"`
+	State       int            `json:"state" xml:"state" structs:"state" gorm:"column:state"`
 }
 
 //KafkaMsg *
@@ -76,25 +72,70 @@ func (k *KafkaMsg) Encode() ([]byte, error) {
 	return k.encoded, k.err
 }
 
+//KafkaClient Kafka 客户端接口, 测试时可使用 MemKafka 替换
+type KafkaClient interface {
+	SyncSend(ctx context.Context, topic string, msg KafkaMsg, opts ...KafkaSendOpt) (partition int32, offset int64, err error)
+	ASyncSend(ctx context.Context, topic string, msg KafkaMsg, opts ...KafkaSendOpt)
+	ASyncSendReport(ctx context.Context, topic string, msg KafkaMsg, opts ...KafkaSendOpt) *KafkaDelivery
+	SyncSendMessage(topic string, msg KafkaMsg, key ...string) (partition int32, offset int64, err error)
+	ASyncSendMessage(topic string, msg KafkaMsg, key ...string)
+	SubscribeMessage(ctx context.Context, topics []string, group string,
+		onMessage func(ctx context.Context, msg *KafkaMessage) error,
+		opts ...KafkaConsumerOpt) error
+	SubscribeBatch(ctx context.Context, topics []string, group string, batch KafkaBatchOpt,
+		onBatch func(ctx context.Context, msgs []*KafkaMessage) error,
+		opts ...KafkaConsumerOpt) error
+	Subscribe(ctx context.Context, topics []string, group string,
+		onMessage func(topic string, partition int32, offset int64, key, value []byte) error,
+		opts ...KafkaConsumerOpt) error
+	Subscription(topics []string, group string,
+		onMessage func(topic string, partition int32, offset int64, key, value []byte) error)
+	MwKafka(next echo.HandlerFunc) echo.HandlerFunc
+	Close() error
+}
+
 //Kafka *
 type Kafka struct {
 	BrokerList             []string
+	Config                 *KafkaConfig
 	SyncProducerCollector  sarama.SyncProducer
 	AsyncProducerCollector sarama.AsyncProducer
+	metrics                kafkaMetrics
+	headersWarning         sync.Once
 }
 
-//NewKafka *
-func NewKafka(brokerList []string) (kafka *Kafka, err error) {
+//NewKafka 未指定 config 时使用 DefaultKafkaConfig
+func NewKafka(brokerList []string, configs ...*KafkaConfig) (kafka *Kafka, err error) {
 
 	if len(brokerList) == 0 {
 		return nil, fmt.Errorf("%s", "Invalid broker data.")
 	}
 
+	var config *KafkaConfig
+	if len(configs) > 0 && nil != configs[0] {
+		config = configs[0]
+	} else if config, err = DefaultKafkaConfig(); nil != err {
+		return nil, err
+	}
+
+	syncProducer, err := newSyncProducerCollector(brokerList, config)
+	if nil != err {
+		return nil, err
+	}
+
+	asyncProducer, err := newASyncProducerCollector(brokerList, config)
+	if nil != err {
+		syncProducer.Close()
+		return nil, err
+	}
+
 	kafka = &Kafka{
 		BrokerList:             brokerList,
-		SyncProducerCollector:  newSyncProducerCollector(brokerList),
-		AsyncProducerCollector: newASyncProducerCollector(brokerList),
+		Config:                 config,
+		SyncProducerCollector:  syncProducer,
+		AsyncProducerCollector: asyncProducer,
 	}
+	go kafka.asyncReports()
 
 	return kafka, nil
 }
@@ -102,109 +143,78 @@ func NewKafka(brokerList []string) (kafka *Kafka, err error) {
 //Close *
 func (k *Kafka) Close() error {
 	if err := k.SyncProducerCollector.Close(); err != nil {
-		log.Errorf("Failed to shut down sync producer collector cleanly", err)
+		log.Errorf("Failed to shut down sync producer collector cleanly: %v", err)
 	}
 
 	if err := k.AsyncProducerCollector.Close(); err != nil {
-		log.Errorf("Failed to shut down async producer collector cleanly", err)
+		log.Errorf("Failed to shut down async producer collector cleanly: %v", err)
 	}
 	return nil
 }
 
-//SyncSendMessage *
-func (k *Kafka) SyncSendMessage(topic string, msg KafkaMsg, key ...string) (partition int32, offset int64, err error) {
-	topic = strings.TrimSpace(topic)
-
-	producerMessage := &sarama.ProducerMessage{
-		Topic: topic,
-		Value: &msg,
-	}
-
-	if len(key) > 0 && len(key[0]) > 0 {
-		producerMessage.Key = sarama.StringEncoder(key[0])
-	}
-
+//SyncSend 同步发送, headers 包含 content-type, schema 版本及 ctx 中的 trace context
+//record headers 需要 Kafka 0.11 以上版本, 配置的版本低于 0.11 时不发送 headers
+func (k *Kafka) SyncSend(ctx context.Context, topic string, msg KafkaMsg, opts ...KafkaSendOpt) (partition int32, offset int64, err error) {
+	producerMessage := newProducerMessage(ctx, topic, &msg, firstSendOpt(opts), k.headers())
 	partition, offset, err = k.SyncProducerCollector.SendMessage(producerMessage)
-
 	return
 }
 
-//ASyncSendMessage *
-func (k *Kafka) ASyncSendMessage(topic string, msg KafkaMsg, key ...string) {
-	topic = strings.TrimSpace(topic)
-
-	producerMessage := &sarama.ProducerMessage{
-		Topic: topic,
-		Value: &msg,
-	}
+//ASyncSend 异步发送, headers 同 SyncSend, 不关心结果时使用; 需要结果时使用 ASyncSendReport
+func (k *Kafka) ASyncSend(ctx context.Context, topic string, msg KafkaMsg, opts ...KafkaSendOpt) {
+	atomic.AddInt64(&k.metrics.inFlight, 1)
+	k.AsyncProducerCollector.Input() <- newProducerMessage(ctx, topic, &msg, firstSendOpt(opts), k.headers())
+}
 
-	if len(key) > 0 && len(key[0]) > 0 {
-		producerMessage.Key = sarama.StringEncoder(key[0])
+//headers 配置的版本是否支持 record headers, 不支持时记录一次警告
+func (k *Kafka) headers() bool {
+	if nil != k.Config && k.Config.version().IsAtLeast(sarama.V0_11_0_0) {
+		return true
 	}
-
-	k.AsyncProducerCollector.Input() <- producerMessage
+	k.headersWarning.Do(func() {
+		log.Warnf("Kafka version is lower than %v, record headers (message-id, request id, trace context) are dropped.", sarama.V0_11_0_0)
+	})
+	return false
 }
 
-func newSyncProducerCollector(brokerList []string) sarama.SyncProducer {
+//SyncSendMessage *
+func (k *Kafka) SyncSendMessage(topic string, msg KafkaMsg, key ...string) (partition int32, offset int64, err error) {
+	return k.SyncSend(context.Background(), topic, msg, sendOpt(key))
+}
 
-	config := sarama.NewConfig()
+//ASyncSendMessage *
+func (k *Kafka) ASyncSendMessage(topic string, msg KafkaMsg, key ...string) {
+	k.ASyncSend(context.Background(), topic, msg, sendOpt(key))
+}
 
-	tlsConfig := getKafkaTLSConfigByEnv()
-	if tlsConfig != nil {
-		config.Net.TLS.Enable = true
-		config.Net.TLS.Config = tlsConfig
+func newSyncProducerCollector(brokerList []string, kafkaConfig *KafkaConfig) (sarama.SyncProducer, error) {
+	config, err := kafkaConfig.producerConfig(kafkaConfig.Sync, defaultKafkaSyncProducer)
+	if nil != err {
+		return nil, err
 	}
-
-	config.Version = DefaultKafkaVersion
-	config.Producer.RequiredAcks = sarama.WaitForAll
-	config.Producer.Retry.Max = 10
 	config.Producer.Return.Successes = true
 
 	producer, err := sarama.NewSyncProducer(brokerList, config)
 	if err != nil {
-		log.Fatalf("Failed to start Sarama producer: %v", err)
+		return nil, fmt.Errorf("Failed to start Sarama sync producer: %v", err)
 	}
 
-	return producer
+	return producer, nil
 }
 
-func newASyncProducerCollector(brokerList []string) sarama.AsyncProducer {
-	config := sarama.NewConfig()
-
-	tlsConfig := getKafkaTLSConfigByEnv()
-	if tlsConfig != nil {
-		config.Net.TLS.Enable = true
-		config.Net.TLS.Config = tlsConfig
+func newASyncProducerCollector(brokerList []string, kafkaConfig *KafkaConfig) (sarama.AsyncProducer, error) {
+	config, err := kafkaConfig.producerConfig(kafkaConfig.Async, defaultKafkaAsyncProducer)
+	if nil != err {
+		return nil, err
 	}
-
-	config.Version = DefaultKafkaVersion
-	config.Producer.RequiredAcks = sarama.WaitForLocal
-	config.Producer.Compression = sarama.CompressionSnappy
-	config.Producer.Flush.Frequency = 500 * time.Millisecond
+	config.Producer.Return.Successes = true
 
 	producer, err := sarama.NewAsyncProducer(brokerList, config)
 	if err != nil {
-		log.Fatalf("Failed to start Sarama producer: %v", err)
+		return nil, fmt.Errorf("Failed to start Sarama async producer: %v", err)
 	}
 
-	go func() {
-		for err := range producer.Errors() {
-			log.Errorf("Failed to write access log entry: %v", err)
-		}
-	}()
-
-	return producer
-}
-
-func getKafkaTLSConfigByEnv() (t *tls.Config) {
-	certFile := utils.GetENV("KAFKA_TLS_CERT")
-	keyFile := utils.GetENV("KAFKA_TLS_KEY")
-	caFile := utils.GetENV("KAFKA_TLS_CA")
-	verifySSL := utils.GetENVToBool("KAFKA_TLS_VERIFYSSL")
-
-	t = utils.CreateTLSConfig(certFile, keyFile, caFile, verifySSL)
-
-	return
+	return producer, nil
 }
 
 //MwKafka Kafa middleware
@@ -214,47 +224,3 @@ func (k *Kafka) MwKafka(next echo.HandlerFunc) echo.HandlerFunc {
 		return next(c)
 	}
 }
-
-//Subscription *
-func (k *Kafka) Subscription(topics []string, group string,
-	onMessage func(topic string, partition int32, offset int64, key, value []byte) error) {
-
-	config := cluster.NewConfig()
-	config.Version = DefaultKafkaVersion
-	config.Consumer.Return.Errors = true
-	config.Group.Return.Notifications = true
-
-	consumer, err := cluster.NewConsumer(k.BrokerList, group, topics, config)
-	if err != nil {
-		log.Printf("Kafka New consumer error: %s\n", err.Error())
-	}
-	defer consumer.Close()
-
-	go func() {
-		for err := range consumer.Errors() {
-			log.Printf("Kafka New consumer errors: %s\n", err.Error())
-		}
-	}()
-
-	go func() {
-		for ntf := range consumer.Notifications() {
-			log.Printf("Kafka consumer notifications: %+v\n", ntf)
-		}
-	}()
-
-	for {
-		select {
-		case msg, ok := <-consumer.Messages():
-			if ok {
-				if nil != onMessage {
-					err := onMessage(msg.Topic, msg.Partition, msg.Offset, msg.Key, msg.Value)
-					if err != nil {
-						log.Printf("Kafka callback method error: %s\n", err.Error())
-					} else {
-						consumer.MarkOffset(msg, "")
-					}
-				}
-			}
-		}
-	}
-}
@@ -0,0 +1,188 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+//KafkaBatchOpt 批量消费参数, 每个分区单独累积
+type KafkaBatchOpt struct {
+	//Size 每批最多消息数, 默认 100
+	Size int
+	//Timeout 第一条消息到达后最多等待时间, 默认 1s
+	Timeout time.Duration
+}
+
+//SubscribeBatch 批量订阅并阻塞, 每个分区累积到 Size 条或等待 Timeout 后调用 onBatch
+//onBatch 成功后才标记整批 offset; 失败时按 KafkaConsumerOpt.Retry 的 Backoff 重试整批,
+//重试 Retries 次后转发到 DeadLetterTopic, 转发中途失败时只重试未转发的消息, 未配置死信 topic 时一直重试;
+//批量模式不使用 RetryTopics
+func (k *Kafka) SubscribeBatch(ctx context.Context, topics []string, group string, batch KafkaBatchOpt,
+	onBatch func(ctx context.Context, msgs []*KafkaMessage) error,
+	opts ...KafkaConsumerOpt) error {
+
+	if nil == onBatch {
+		return errors.New("Kafka onBatch callback is nil.")
+	}
+
+	opt := consumerOpt(opts)
+
+	if batch.Size <= 0 {
+		batch.Size = 100
+	}
+	if batch.Timeout <= 0 {
+		batch.Timeout = time.Second
+	}
+
+	handler := &kafkaBatchHandler{
+		kafkaGroupHandler: &kafkaGroupHandler{opt: opt},
+		kafka:             k,
+		batch:             batch,
+		onBatch:           onBatch,
+	}
+	if opt.Concurrency > 0 {
+		handler.sem = make(chan struct{}, opt.Concurrency)
+	}
+
+	return k.consume(ctx, topics, group, opt, handler)
+}
+
+type kafkaBatchHandler struct {
+	*kafkaGroupHandler
+	kafka   *Kafka
+	batch   KafkaBatchOpt
+	onBatch func(ctx context.Context, msgs []*KafkaMessage) error
+}
+
+//ConsumeClaim 每个分区一个 goroutine, 分区内按顺序累积并处理
+//会话结束时未处理的消息不标记 offset, 重平衡后重新投递
+func (h *kafkaBatchHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	ctx := session.Context()
+	batch := make([]*sarama.ConsumerMessage, 0, h.batch.Size)
+
+	var timer *time.Timer
+	var timeout <-chan time.Time
+	defer func() {
+		if nil != timer {
+			timer.Stop()
+		}
+	}()
+
+	flush := func() error {
+		if nil != timer {
+			timer.Stop()
+			timer, timeout = nil, nil
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := h.process(ctx, batch); nil != err {
+			return err
+		}
+		session.MarkMessage(batch[len(batch)-1], "")
+		batch = make([]*sarama.ConsumerMessage, 0, h.batch.Size)
+		return nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				flush()
+				return nil
+			}
+			batch = append(batch, msg)
+			if len(batch) == 1 {
+				timer = time.NewTimer(h.batch.Timeout)
+				timeout = timer.C
+			}
+			if len(batch) >= h.batch.Size {
+				if err := flush(); nil != err {
+					return nil
+				}
+			}
+		case <-timeout:
+			if err := flush(); nil != err {
+				return nil
+			}
+		}
+	}
+}
+
+//process 处理整批, 失败时退避重试, 只有 ctx 取消时返回错误
+func (h *kafkaBatchHandler) process(ctx context.Context, batch []*sarama.ConsumerMessage) error {
+	if nil != h.sem {
+		select {
+		case h.sem <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		defer func() { <-h.sem }()
+	}
+
+	msgs := make([]*KafkaMessage, 0, len(batch))
+	for _, msg := range batch {
+		msgs = append(msgs, newKafkaMessage(msg))
+	}
+
+	policy := h.opt.Retry
+	backoff, maxBackoff := time.Second, 30*time.Second
+	if nil != policy && policy.Backoff > 0 {
+		backoff = policy.Backoff
+	}
+	if nil != policy && policy.MaxBackoff > 0 {
+		maxBackoff = policy.MaxBackoff
+	}
+
+	//forwarded 已转发到死信 topic 的消息数, 转发中途失败时重试只转发剩余消息, 不再调用 onBatch
+	forwarded, failedAttempt := 0, 0
+	var cause error
+	for attempt := 1; ; attempt++ {
+		if forwarded == 0 {
+			if cause = h.onBatch(ctx, msgs); nil == cause {
+				return nil
+			}
+			if nil != ctx.Err() {
+				return ctx.Err()
+			}
+			h.opt.onError(cause)
+			failedAttempt = attempt
+		}
+
+		if nil != policy && len(policy.DeadLetterTopic) != 0 && failedAttempt > policy.Retries {
+			n, ferr := h.deadLetter(batch[forwarded:], cause, failedAttempt)
+			if forwarded += n; nil == ferr {
+				return nil
+			}
+			h.opt.onError(ferr)
+		}
+
+		if err := sleepContext(ctx, backoff); nil != err {
+			return err
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+//deadLetter 按顺序转发到死信 topic, 返回成功转发的消息数
+func (h *kafkaBatchHandler) deadLetter(batch []*sarama.ConsumerMessage, cause error, attempts int) (n int, err error) {
+	policy := h.opt.Retry
+	for _, msg := range batch {
+		failed := newKafkaFailedMessage(msg)
+		failed.Attempts = attempts
+		failed.Error = cause.Error()
+		failed.FailedAt = time.Now()
+		if err = h.kafka.forwardFailed(failed, len(policy.RetryTopics), policy); nil != err {
+			return
+		}
+		n++
+	}
+	return
+}
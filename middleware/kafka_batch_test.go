@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+//testSyncProducer 第 failAt 次发送失败一次
+type testSyncProducer struct {
+	sends  int
+	failAt int
+	sent   []int64
+}
+
+func (p *testSyncProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	if p.sends++; p.sends == p.failAt {
+		return 0, 0, errors.New("send failed")
+	}
+	b, _ := msg.Value.Encode()
+	failed := &KafkaFailedMessage{}
+	if err = json.Unmarshal(b, failed); nil != err {
+		return
+	}
+	p.sent = append(p.sent, failed.Offset)
+	return
+}
+
+func (p *testSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	for _, msg := range msgs {
+		if _, _, err := p.SendMessage(msg); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *testSyncProducer) Close() error { return nil }
+
+func TestBatchDeadLetter(t *testing.T) {
+	producer := &testSyncProducer{failAt: 2}
+	calls := 0
+	handler := &kafkaBatchHandler{
+		kafkaGroupHandler: &kafkaGroupHandler{opt: KafkaConsumerOpt{
+			Retry:   &KafkaRetryPolicy{Backoff: time.Millisecond, DeadLetterTopic: "notify.dlq"},
+			OnError: func(err error) {},
+		}},
+		kafka: &Kafka{SyncProducerCollector: producer},
+		onBatch: func(ctx context.Context, msgs []*KafkaMessage) error {
+			calls++
+			return errors.New("failed")
+		},
+	}
+
+	batch := make([]*sarama.ConsumerMessage, 0, 3)
+	for offset := int64(0); offset < 3; offset++ {
+		batch = append(batch, &sarama.ConsumerMessage{Topic: "notify", Offset: offset})
+	}
+
+	if err := handler.process(context.Background(), batch); nil != err {
+		t.Fatalf("process() error = %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("onBatch calls = %d, want 1", calls)
+	}
+	if len(producer.sent) != 3 || producer.sent[0] != 0 || producer.sent[1] != 1 || producer.sent[2] != 2 {
+		t.Errorf("dead letters = %v, want [0 1 2]", producer.sent)
+	}
+}
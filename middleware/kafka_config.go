@@ -0,0 +1,205 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/GreatSir/realclouds_go/utils"
+	"github.com/Shopify/sarama"
+	"github.com/xdg-go/scram"
+)
+
+const (
+	//KAFKA_SASL_PLAIN *
+	KAFKA_SASL_PLAIN = sarama.SASLTypePlaintext
+	//KAFKA_SASL_SCRAM_SHA256 *
+	KAFKA_SASL_SCRAM_SHA256 = sarama.SASLTypeSCRAMSHA256
+	//KAFKA_SASL_SCRAM_SHA512 *
+	KAFKA_SASL_SCRAM_SHA512 = sarama.SASLTypeSCRAMSHA512
+)
+
+var (
+	defaultKafkaSyncProducer = KafkaProducerConfig{
+		RequiredAcks: sarama.WaitForAll,
+		Compression:  sarama.CompressionNone,
+		RetryMax:     10,
+	}
+	defaultKafkaAsyncProducer = KafkaProducerConfig{
+		RequiredAcks:   sarama.WaitForLocal,
+		Compression:    sarama.CompressionSnappy,
+		RetryMax:       3,
+		FlushFrequency: 500 * time.Millisecond,
+	}
+)
+
+//KafkaConfig Kafka 客户端配置
+type KafkaConfig struct {
+	Version  sarama.KafkaVersion
+	ClientID string
+	TLS      *tls.Config
+	SASL     KafkaSASL
+	Sync     KafkaProducerConfig
+	Async    KafkaProducerConfig
+}
+
+//KafkaProducerConfig 生产者配置
+//全部为零值时使用默认配置; RequiredAcks, RetryMax, FlushFrequency 为零值时使用默认值
+type KafkaProducerConfig struct {
+	RequiredAcks   sarama.RequiredAcks
+	Compression    sarama.CompressionCodec
+	RetryMax       int
+	FlushFrequency time.Duration
+}
+
+//KafkaSASL SASL 认证, Mechanism 为空时不启用
+type KafkaSASL struct {
+	Mechanism string
+	User      string
+	Password  string
+}
+
+//DefaultKafkaConfig 默认配置, TLS/SASL/版本/ClientID 读取环境变量
+func DefaultKafkaConfig() (*KafkaConfig, error) {
+	config := &KafkaConfig{
+		Version:  DefaultKafkaVersion,
+		ClientID: utils.GetENV("KAFKA_CLIENT_ID"),
+		SASL: KafkaSASL{
+			Mechanism: utils.GetENV("KAFKA_SASL_MECHANISM"),
+			User:      utils.GetENV("KAFKA_SASL_USER"),
+			Password:  utils.GetENV("KAFKA_SASL_PASSWORD"),
+		},
+		Sync:  defaultKafkaSyncProducer,
+		Async: defaultKafkaAsyncProducer,
+	}
+
+	if version := utils.GetENV("KAFKA_VERSION"); len(version) != 0 {
+		v, err := sarama.ParseKafkaVersion(version)
+		if nil != err {
+			return nil, err
+		}
+		config.Version = v
+	}
+
+	tlsConfig, err := utils.LoadTLSConfig(
+		utils.GetENV("KAFKA_TLS_CERT"),
+		utils.GetENV("KAFKA_TLS_KEY"),
+		utils.GetENV("KAFKA_TLS_CA"),
+		utils.GetENVToBool("KAFKA_TLS_VERIFYSSL"))
+	if nil != err {
+		return nil, err
+	}
+	config.TLS = tlsConfig
+
+	return config, nil
+}
+
+//apply 设置版本, ClientID, TLS 及 SASL
+func (c *KafkaConfig) apply(config *sarama.Config) error {
+	config.Version = c.version()
+
+	if len(c.ClientID) != 0 {
+		config.ClientID = c.ClientID
+	}
+
+	if nil != c.TLS {
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = c.TLS
+	}
+
+	mechanism := strings.ToUpper(strings.TrimSpace(c.SASL.Mechanism))
+	if len(mechanism) == 0 {
+		return nil
+	}
+
+	config.Net.SASL.Enable = true
+	config.Net.SASL.User = c.SASL.User
+	config.Net.SASL.Password = c.SASL.Password
+	config.Net.SASL.Handshake = true
+
+	switch mechanism {
+	case KAFKA_SASL_PLAIN:
+		config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case KAFKA_SASL_SCRAM_SHA256:
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &kafkaSCRAMClient{HashGeneratorFcn: sha256.New}
+		}
+	case KAFKA_SASL_SCRAM_SHA512:
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &kafkaSCRAMClient{HashGeneratorFcn: sha512.New}
+		}
+	default:
+		return fmt.Errorf("Unsupported kafka SASL mechanism: %s", c.SASL.Mechanism)
+	}
+	return nil
+}
+
+//withDefault 零值字段使用 def 中的值
+func (p KafkaProducerConfig) withDefault(def KafkaProducerConfig) KafkaProducerConfig {
+	if p == (KafkaProducerConfig{}) {
+		return def
+	}
+	if p.RequiredAcks == 0 {
+		p.RequiredAcks = def.RequiredAcks
+	}
+	if p.RetryMax == 0 {
+		p.RetryMax = def.RetryMax
+	}
+	if p.FlushFrequency == 0 {
+		p.FlushFrequency = def.FlushFrequency
+	}
+	return p
+}
+
+//version 未设置时为 DefaultKafkaVersion
+func (c *KafkaConfig) version() sarama.KafkaVersion {
+	if c.Version == (sarama.KafkaVersion{}) {
+		return DefaultKafkaVersion
+	}
+	return c.Version
+}
+
+//producerConfig 生产者配置, p 中零值字段使用 def 中的值
+func (c *KafkaConfig) producerConfig(p, def KafkaProducerConfig) (*sarama.Config, error) {
+	config := sarama.NewConfig()
+	if err := c.apply(config); nil != err {
+		return nil, err
+	}
+
+	p = p.withDefault(def)
+
+	config.Producer.RequiredAcks = p.RequiredAcks
+	config.Producer.Compression = p.Compression
+	config.Producer.Retry.Max = p.RetryMax
+	config.Producer.Flush.Frequency = p.FlushFrequency
+
+	return config, nil
+}
+
+type kafkaSCRAMClient struct {
+	*scram.Client
+	*scram.ClientConversation
+	scram.HashGeneratorFcn
+}
+
+func (x *kafkaSCRAMClient) Begin(userName, password, authzID string) (err error) {
+	x.Client, err = x.HashGeneratorFcn.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	x.ClientConversation = x.Client.NewConversation()
+	return nil
+}
+
+func (x *kafkaSCRAMClient) Step(challenge string) (response string, err error) {
+	return x.ClientConversation.Step(challenge)
+}
+
+func (x *kafkaSCRAMClient) Done() bool {
+	return x.ClientConversation.Done()
+}
@@ -0,0 +1,213 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+	log "github.com/sirupsen/logrus"
+)
+
+//KafkaConsumerOpt 消费者参数
+type KafkaConsumerOpt struct {
+	//Concurrency 同时处理消息的分区数上限, 0 表示不限; 同一分区内始终按顺序处理
+	Concurrency int
+	//OnError 消费错误及回调错误, 默认写日志
+	OnError func(err error)
+	//OnAssigned 重平衡后当前分配的分区
+	OnAssigned func(claims map[string][]int32)
+	//OnRevoked 重平衡时被回收的分区
+	OnRevoked func(claims map[string][]int32)
+	//Retry 失败重试及死信策略; 为 nil 或重试后无法转发时, 结束当前会话, 从失败的消息重新消费
+	//无法处理的消息会一直阻塞所在分区, 需要跳过时配置 Retry 转发到死信 topic 或设置 SkipFailed
+	Retry *KafkaRetryPolicy
+	//SkipFailed 处理失败(包括 Retry 转发失败)时调用 OnError 后跳过该消息, 不再重新消费
+	SkipFailed bool
+}
+
+func consumerOpt(opts []KafkaConsumerOpt) KafkaConsumerOpt {
+	if len(opts) > 0 {
+		return opts[0]
+	}
+	return KafkaConsumerOpt{}
+}
+
+func (o KafkaConsumerOpt) onError(err error) {
+	if nil != o.OnError {
+		o.OnError(err)
+		return
+	}
+	log.Errorf("Kafka consumer error: %v", err)
+}
+
+//Subscription 订阅并阻塞, 直到出错; 与之前的版本一致, 处理失败的消息记录日志后跳过
+//
+//Deprecated: 使用 Subscribe, 可通过 ctx 停止
+func (k *Kafka) Subscription(topics []string, group string,
+	onMessage func(topic string, partition int32, offset int64, key, value []byte) error) {
+
+	if err := k.Subscribe(context.Background(), topics, group, onMessage, KafkaConsumerOpt{SkipFailed: true}); nil != err {
+		log.Errorf("Kafka subscription error: %v", err)
+	}
+}
+
+//Subscribe 基于 ConsumerGroup 订阅并阻塞, ctx 取消后处理完当前消息, 提交 offset 并关闭消费者
+//onMessage 返回错误时不标记 offset
+func (k *Kafka) Subscribe(ctx context.Context, topics []string, group string,
+	onMessage func(topic string, partition int32, offset int64, key, value []byte) error,
+	opts ...KafkaConsumerOpt) error {
+
+	if nil == onMessage {
+		return errors.New("Kafka onMessage callback is nil.")
+	}
+
+	return k.SubscribeMessage(ctx, topics, group, func(ctx context.Context, msg *KafkaMessage) error {
+		return onMessage(msg.Topic, msg.Partition, msg.Offset, msg.Key, msg.Value)
+	}, opts...)
+}
+
+//SubscribeMessage 同 Subscribe, 回调收到包含 headers 及时间戳的 KafkaMessage
+//回调的 ctx 中带有消息 headers 中的 trace context, 可通过 KafkaTraceFromContext 获取
+func (k *Kafka) SubscribeMessage(ctx context.Context, topics []string, group string,
+	onMessage func(ctx context.Context, msg *KafkaMessage) error,
+	opts ...KafkaConsumerOpt) error {
+
+	if nil == onMessage {
+		return errors.New("Kafka onMessage callback is nil.")
+	}
+
+	opt := consumerOpt(opts)
+
+	handler := &kafkaGroupHandler{
+		opt: opt,
+		onMessage: func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+			m := newKafkaMessage(msg)
+			return onMessage(m.Context(ctx), m)
+		},
+	}
+	if nil != opt.Retry {
+		handler.onMessage = k.retryHandler(opt.Retry, handler.onMessage)
+		handler.wait = opt.Retry.wait
+		//不修改调用方的 topics
+		topics = append(append(make([]string, 0, len(topics)), topics...), opt.Retry.topics()...)
+	}
+	if opt.Concurrency > 0 {
+		handler.sem = make(chan struct{}, opt.Concurrency)
+	}
+
+	return k.consume(ctx, topics, group, opt, handler)
+}
+
+//consume 创建 ConsumerGroup 并持续消费, 重平衡后重新加入, 直到 ctx 取消
+func (k *Kafka) consume(ctx context.Context, topics []string, group string,
+	opt KafkaConsumerOpt, handler sarama.ConsumerGroupHandler) (err error) {
+
+	consumerGroup, err := k.newConsumerGroup(group)
+	if nil != err {
+		return err
+	}
+	defer func() {
+		if cerr := consumerGroup.Close(); nil != cerr && nil == err {
+			err = cerr
+		}
+	}()
+
+	go func() {
+		for cerr := range consumerGroup.Errors() {
+			opt.onError(cerr)
+		}
+	}()
+
+	for {
+		if err = consumerGroup.Consume(ctx, topics, handler); nil != err {
+			if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+				return nil
+			}
+			return err
+		}
+		if nil != ctx.Err() {
+			return nil
+		}
+	}
+}
+
+//newConsumerGroup ConsumerGroup 需要 Kafka 0.10.2 以上版本
+func (k *Kafka) newConsumerGroup(group string) (sarama.ConsumerGroup, error) {
+	config := sarama.NewConfig()
+	if err := k.Config.apply(config); nil != err {
+		return nil, err
+	}
+	if !config.Version.IsAtLeast(sarama.V0_10_2_0) {
+		config.Version = sarama.V0_10_2_0
+	}
+	config.Consumer.Return.Errors = true
+
+	return sarama.NewConsumerGroup(k.BrokerList, group, config)
+}
+
+type kafkaGroupHandler struct {
+	opt       KafkaConsumerOpt
+	sem       chan struct{}
+	wait      func(ctx context.Context, msg *sarama.ConsumerMessage) error
+	onMessage func(ctx context.Context, msg *sarama.ConsumerMessage) error
+}
+
+func (h *kafkaGroupHandler) Setup(session sarama.ConsumerGroupSession) error {
+	if nil != h.opt.OnAssigned {
+		h.opt.OnAssigned(session.Claims())
+	}
+	return nil
+}
+
+func (h *kafkaGroupHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	if nil != h.opt.OnRevoked {
+		h.opt.OnRevoked(session.Claims())
+	}
+	session.Commit()
+	return nil
+}
+
+//ConsumeClaim 每个分区一个 goroutine, 分区内按顺序处理
+//处理失败时不标记 offset 并返回错误, 会话结束后重新加入, 从失败的消息重新消费; SkipFailed 时跳过该消息
+func (h *kafkaGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	ctx := session.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			if err := h.handle(ctx, msg); nil != err {
+				if nil != ctx.Err() {
+					return nil
+				}
+				if h.opt.SkipFailed {
+					h.opt.onError(fmt.Errorf("Kafka message %s/%d/%d skipped: %v", msg.Topic, msg.Partition, msg.Offset, err))
+					session.MarkMessage(msg, "")
+					continue
+				}
+				//避免失败消息立即重新投递
+				sleepContext(ctx, time.Second)
+				return fmt.Errorf("Kafka message %s/%d/%d: %v", msg.Topic, msg.Partition, msg.Offset, err)
+			}
+			session.MarkMessage(msg, "")
+		}
+	}
+}
+
+func (h *kafkaGroupHandler) handle(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	if nil != h.wait {
+		if err := h.wait(ctx, msg); nil != err {
+			return err
+		}
+	}
+	if nil != h.sem {
+		h.sem <- struct{}{}
+		defer func() { <-h.sem }()
+	}
+	return h.onMessage(ctx, msg)
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+type testGroupSession struct {
+	ctx    context.Context
+	marked []int64
+}
+
+func (s *testGroupSession) Claims() map[string][]int32 { return nil }
+func (s *testGroupSession) MemberID() string           { return "" }
+func (s *testGroupSession) GenerationID() int32        { return 0 }
+func (s *testGroupSession) Commit()                    {}
+func (s *testGroupSession) Context() context.Context   { return s.ctx }
+
+func (s *testGroupSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {}
+
+func (s *testGroupSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {
+}
+
+func (s *testGroupSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.marked = append(s.marked, msg.Offset)
+}
+
+type testGroupClaim struct {
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *testGroupClaim) Topic() string                            { return "notify" }
+func (c *testGroupClaim) Partition() int32                         { return 0 }
+func (c *testGroupClaim) InitialOffset() int64                     { return 0 }
+func (c *testGroupClaim) HighWaterMarkOffset() int64               { return 0 }
+func (c *testGroupClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+func TestConsumeClaim(t *testing.T) {
+	tests := []struct {
+		name   string
+		skip   bool
+		marked []int64
+		err    bool
+	}{
+		{"stop on failure", false, []int64{0}, true},
+		{"skip failed", true, []int64{0, 1, 2}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claim := &testGroupClaim{messages: make(chan *sarama.ConsumerMessage, 3)}
+			for offset := int64(0); offset < 3; offset++ {
+				claim.messages <- &sarama.ConsumerMessage{Topic: "notify", Offset: offset}
+			}
+			close(claim.messages)
+
+			errs := 0
+			handler := &kafkaGroupHandler{
+				opt: KafkaConsumerOpt{SkipFailed: tt.skip, OnError: func(err error) { errs++ }},
+				onMessage: func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+					if msg.Offset == 1 {
+						return errors.New("failed")
+					}
+					return nil
+				},
+			}
+
+			session := &testGroupSession{ctx: context.Background()}
+			err := handler.ConsumeClaim(session, claim)
+			if tt.err != (nil != err) {
+				t.Errorf("ConsumeClaim() error = %v, want error %v", err, tt.err)
+			}
+			if len(session.marked) != len(tt.marked) {
+				t.Fatalf("marked = %v, want %v", session.marked, tt.marked)
+			}
+			for i := range tt.marked {
+				if session.marked[i] != tt.marked[i] {
+					t.Errorf("marked = %v, want %v", session.marked, tt.marked)
+				}
+			}
+			if tt.skip && errs != 1 {
+				t.Errorf("OnError called %d times, want 1", errs)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	//KAFKA_DEDUP_PREFIX Redis 去重 key 前缀
+	KAFKA_DEDUP_PREFIX = "kafka:dedup:"
+
+	//DEFAULT_KAFKA_DEDUP_LOCK_TTL 默认处理中状态保留秒数
+	DEFAULT_KAFKA_DEDUP_LOCK_TTL = 300
+
+	kafkaDedupProcessing = "processing"
+	kafkaDedupDone       = "done"
+)
+
+var (
+	//ErrKafkaDedupInProgress 同一消息正在被处理; DedupHandler 返回该错误时消息不标记 offset,
+	//消费者结束当前会话后从该消息重新消费
+	ErrKafkaDedupInProgress = errors.New("Kafka message is being processed.")
+)
+
+//KafkaDedupStore 消息去重存储
+//Begin 首次处理返回 true, 已处理完成返回 false, 正在处理返回 ErrKafkaDedupInProgress;
+//处理成功后 Commit, 失败后 Release 以便重新处理; 处理中途退出时, 锁过期后可重新处理
+//锁的有效期应不小于处理函数的最长耗时, 否则处理未完成时同一消息会被再次处理
+type KafkaDedupStore interface {
+	Begin(key string) (bool, error)
+	Commit(key string) error
+	Release(key string) error
+}
+
+//KafkaDedupKey 去重 key: 优先使用 message-id header, 否则为 topic/partition/offset
+//message-id header 需要生产端 KafkaConfig.Version >= sarama.V0_11_0_0, 否则重复发送的消息 offset 不同, 无法去重
+func KafkaDedupKey(msg *KafkaMessage) string {
+	if id := strings.TrimSpace(msg.Header(KAFKA_HEADER_MESSAGE_ID)); len(id) != 0 {
+		return id
+	}
+	return fmt.Sprintf("%s/%d/%d", msg.Topic, msg.Partition, msg.Offset)
+}
+
+//DedupHandler 包装 onMessage, 已处理过的消息直接跳过
+func DedupHandler(store KafkaDedupStore,
+	onMessage func(ctx context.Context, msg *KafkaMessage) error) func(ctx context.Context, msg *KafkaMessage) error {
+
+	return func(ctx context.Context, msg *KafkaMessage) error {
+		key := KafkaDedupKey(msg)
+
+		first, err := store.Begin(key)
+		if nil != err {
+			return err
+		}
+		if !first {
+			return nil
+		}
+
+		if err := onMessage(ctx, msg); nil != err {
+			store.Release(key)
+			return err
+		}
+		return store.Commit(key)
+	}
+}
+
+//RedisDedupStore 基于 Redis SET NX 的去重存储
+type RedisDedupStore struct {
+	Redis  *Redis
+	Prefix string
+	//LockTTL 处理中状态保留秒数, 应不小于处理函数的最长耗时
+	LockTTL int
+	//TTL 处理完成状态保留秒数, 应大于消息可能重复投递的时间窗口
+	TTL int
+}
+
+//NewRedisDedupStore lockTTL 为处理中状态保留秒数, 不大于 0 时使用 DEFAULT_KAFKA_DEDUP_LOCK_TTL
+func NewRedisDedupStore(r *Redis, lockTTL int) *RedisDedupStore {
+	if lockTTL <= 0 {
+		lockTTL = DEFAULT_KAFKA_DEDUP_LOCK_TTL
+	}
+	return &RedisDedupStore{
+		Redis:   r,
+		Prefix:  KAFKA_DEDUP_PREFIX,
+		LockTTL: lockTTL,
+		TTL:     7 * 86400,
+	}
+}
+
+//Begin *
+func (s *RedisDedupStore) Begin(key string) (bool, error) {
+	conn := s.Redis.RedisPool.Get()
+	defer conn.Close()
+	if err := conn.Err(); nil != err {
+		return false, err
+	}
+
+	reply, err := conn.Do("SET", s.Prefix+key, kafkaDedupProcessing, "EX", s.LockTTL, "NX")
+	if nil != err {
+		return false, err
+	}
+	if nil != reply {
+		return true, nil
+	}
+
+	state, err := redis.String(conn.Do("GET", s.Prefix+key))
+	switch {
+	case err == redis.ErrNil:
+		//刚过期, 重新尝试
+		return s.Begin(key)
+	case nil != err:
+		return false, err
+	case state == kafkaDedupDone:
+		return false, nil
+	}
+	return false, ErrKafkaDedupInProgress
+}
+
+//Commit *
+func (s *RedisDedupStore) Commit(key string) error {
+	return s.Redis.Setex(s.Prefix+key, s.TTL, kafkaDedupDone)
+}
+
+//Release *
+func (s *RedisDedupStore) Release(key string) error {
+	return s.Redis.Del(s.Prefix + key)
+}
+
+//KafkaDedup MySQL 去重记录
+type KafkaDedup struct {
+	Key       string    `gorm:"primary_key;column:dedup_key;type:varchar(255)" json:"key" xml:"key"`
+	Done      bool      `gorm:"column:done;type:boolean;default:false" json:"done" xml:"done"`
+	CreatedAt time.Time `gorm:"column:created_at;type:timestamp" json:"created_at" xml:"created_at"`
+	UpdatedAt time.Time `sql:"index" gorm:"column:updated_at;type:timestamp NULL" json:"updated_at" xml:"updated_at"`
+}
+
+//TableName *
+func (KafkaDedup) TableName() string {
+	return "sys_kafka_dedup"
+}
+
+//ClaimKafkaMessage 在事务 tx 中写入去重记录, 首次写入返回 true
+//与业务数据在同一事务中调用时, 事务回滚后消息可重新处理, 不需要 Commit/Release
+func ClaimKafkaMessage(tx *gorm.DB, key string) (bool, error) {
+	result := tx.Set("gorm:insert_modifier", "IGNORE").Create(&KafkaDedup{Key: key, Done: true})
+	if nil != result.Error {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+//MySQLDedupStore 基于 MySQL 唯一主键的去重存储
+type MySQLDedupStore struct {
+	Gorm *gorm.DB
+	//LockTTL 处理中状态超过该时间后可被重新处理, 应不小于处理函数的最长耗时
+	LockTTL time.Duration
+}
+
+//NewMySQLDedupStore lockTTL 不大于 0 时使用 DEFAULT_KAFKA_DEDUP_LOCK_TTL 秒
+func NewMySQLDedupStore(db *gorm.DB, lockTTL time.Duration) (*MySQLDedupStore, error) {
+	if err := db.AutoMigrate(&KafkaDedup{}).Error; nil != err {
+		return nil, err
+	}
+
+	if lockTTL <= 0 {
+		lockTTL = DEFAULT_KAFKA_DEDUP_LOCK_TTL * time.Second
+	}
+
+	store := &MySQLDedupStore{
+		Gorm:    db,
+		LockTTL: lockTTL,
+	}
+	return store, nil
+}
+
+//Begin *
+func (s *MySQLDedupStore) Begin(key string) (bool, error) {
+	result := s.Gorm.Set("gorm:insert_modifier", "IGNORE").Create(&KafkaDedup{Key: key})
+	if nil != result.Error {
+		return false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return true, nil
+	}
+
+	//接管超时未完成的记录
+	result = s.Gorm.Model(&KafkaDedup{}).
+		Where("dedup_key = ? AND done = ? AND updated_at < ?", key, false, time.Now().Add(-s.LockTTL)).
+		UpdateColumn("updated_at", time.Now())
+	if nil != result.Error {
+		return false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return true, nil
+	}
+
+	data := &KafkaDedup{}
+	if err := s.Gorm.Where("dedup_key = ?", key).First(data).Error; nil != err {
+		return false, err
+	}
+	if data.Done {
+		return false, nil
+	}
+	return false, ErrKafkaDedupInProgress
+}
+
+//Commit *
+func (s *MySQLDedupStore) Commit(key string) error {
+	return s.Gorm.Model(&KafkaDedup{}).Where("dedup_key = ?", key).
+		UpdateColumns(map[string]interface{}{"done": true, "updated_at": time.Now()}).Error
+}
+
+//Release *
+func (s *MySQLDedupStore) Release(key string) error {
+	return s.Gorm.Where("dedup_key = ? AND done = ?", key, false).Delete(&KafkaDedup{}).Error
+}
+
+//Purge 删除 before 之前完成的记录
+func (s *MySQLDedupStore) Purge(before time.Time) error {
+	return s.Gorm.Where("done = ? AND updated_at < ?", true, before).Delete(&KafkaDedup{}).Error
+}
@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Shopify/sarama"
+	log "github.com/sirupsen/logrus"
+)
+
+//KafkaDelivery 异步发送结果, Done 关闭后 Partition/Offset/Err 可读
+type KafkaDelivery struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Err       error
+
+	done      chan struct{}
+	mutex     sync.Mutex
+	callbacks []func(d *KafkaDelivery)
+}
+
+func newKafkaDelivery(topic string) *KafkaDelivery {
+	return &KafkaDelivery{
+		Topic: topic,
+		done:  make(chan struct{}),
+	}
+}
+
+//Done 发送完成(成功或失败)后关闭
+func (d *KafkaDelivery) Done() <-chan struct{} {
+	return d.done
+}
+
+//Wait 等待发送结果, ctx 取消时返回 ctx.Err(), 不影响消息发送
+func (d *KafkaDelivery) Wait(ctx context.Context) (partition int32, offset int64, err error) {
+	select {
+	case <-ctx.Done():
+		return -1, -1, ctx.Err()
+	case <-d.done:
+		return d.Partition, d.Offset, d.Err
+	}
+}
+
+//Then 发送完成后回调, 已完成时立即回调
+//回调在 producer 的结果处理 goroutine 中执行, 不应阻塞
+func (d *KafkaDelivery) Then(fn func(d *KafkaDelivery)) *KafkaDelivery {
+	d.mutex.Lock()
+	select {
+	case <-d.done:
+		d.mutex.Unlock()
+		fn(d)
+	default:
+		d.callbacks = append(d.callbacks, fn)
+		d.mutex.Unlock()
+	}
+	return d
+}
+
+func (d *KafkaDelivery) complete(partition int32, offset int64, err error) {
+	d.mutex.Lock()
+	d.Partition = partition
+	d.Offset = offset
+	d.Err = err
+	close(d.done)
+	callbacks := d.callbacks
+	d.callbacks = nil
+	d.mutex.Unlock()
+
+	for _, fn := range callbacks {
+		fn(d)
+	}
+}
+
+//KafkaMetrics 异步发送统计
+type KafkaMetrics struct {
+	InFlight  int64 `json:"in_flight" xml:"in_flight"`
+	Succeeded int64 `json:"succeeded" xml:"succeeded"`
+	Failed    int64 `json:"failed" xml:"failed"`
+}
+
+type kafkaMetrics struct {
+	inFlight  int64
+	succeeded int64
+	failed    int64
+}
+
+func (m *kafkaMetrics) snapshot() KafkaMetrics {
+	return KafkaMetrics{
+		InFlight:  atomic.LoadInt64(&m.inFlight),
+		Succeeded: atomic.LoadInt64(&m.succeeded),
+		Failed:    atomic.LoadInt64(&m.failed),
+	}
+}
+
+//Metrics 异步发送统计
+func (k *Kafka) Metrics() KafkaMetrics {
+	return k.metrics.snapshot()
+}
+
+//ASyncSendReport 异步发送, 返回 KafkaDelivery 获取 partition/offset 或错误
+//ctx 在消息进入发送队列前取消时, 消息不会发送, 返回的 KafkaDelivery 以 ctx.Err() 完成
+func (k *Kafka) ASyncSendReport(ctx context.Context, topic string, msg KafkaMsg, opts ...KafkaSendOpt) *KafkaDelivery {
+	producerMessage := newProducerMessage(ctx, topic, &msg, firstSendOpt(opts), k.headers())
+	delivery := newKafkaDelivery(producerMessage.Topic)
+	producerMessage.Metadata = delivery
+
+	atomic.AddInt64(&k.metrics.inFlight, 1)
+	select {
+	case k.AsyncProducerCollector.Input() <- producerMessage:
+	case <-ctx.Done():
+		atomic.AddInt64(&k.metrics.inFlight, -1)
+		atomic.AddInt64(&k.metrics.failed, 1)
+		delivery.complete(-1, -1, ctx.Err())
+	}
+	return delivery
+}
+
+//asyncReports 处理异步发送结果, 通过 Metadata 关联 KafkaDelivery
+//没有 KafkaDelivery 的消息(ASyncSend/ASyncSendMessage)发送失败时写日志
+func (k *Kafka) asyncReports() {
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		for msg := range k.AsyncProducerCollector.Successes() {
+			atomic.AddInt64(&k.metrics.inFlight, -1)
+			atomic.AddInt64(&k.metrics.succeeded, 1)
+			if delivery, ok := metadataDelivery(msg); ok {
+				delivery.complete(msg.Partition, msg.Offset, nil)
+			}
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		for perr := range k.AsyncProducerCollector.Errors() {
+			atomic.AddInt64(&k.metrics.inFlight, -1)
+			atomic.AddInt64(&k.metrics.failed, 1)
+			if delivery, ok := metadataDelivery(perr.Msg); ok {
+				delivery.complete(-1, -1, perr.Err)
+				continue
+			}
+			log.Errorf("Kafka async send error: %v", perr)
+		}
+	}()
+
+	wg.Wait()
+}
+
+func metadataDelivery(msg *sarama.ProducerMessage) (*KafkaDelivery, bool) {
+	if nil == msg {
+		return nil, false
+	}
+	delivery, ok := msg.Metadata.(*KafkaDelivery)
+	return delivery, ok
+}
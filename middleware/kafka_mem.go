@@ -0,0 +1,269 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+//MemKafkaMessage MemKafka 记录的消息
+type MemKafkaMessage struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   map[string]string
+	Timestamp time.Time
+}
+
+//MemKafka 内存 Kafka, 用于测试: 记录发送的消息, 由测试调用 Deliver 驱动订阅回调
+type MemKafka struct {
+	mutex       sync.Mutex
+	messages    map[string][]MemKafkaMessage
+	offsets     map[string]int64
+	subscribers map[*memKafkaSubscriber]bool
+}
+
+type memKafkaSubscriber struct {
+	topics    map[string]bool
+	group     string
+	onMessage func(ctx context.Context, msg *KafkaMessage) error
+}
+
+var _ KafkaClient = (*Kafka)(nil)
+var _ KafkaClient = (*MemKafka)(nil)
+
+//NewMemKafka *
+func NewMemKafka() *MemKafka {
+	return &MemKafka{
+		messages:    make(map[string][]MemKafkaMessage),
+		offsets:     make(map[string]int64),
+		subscribers: make(map[*memKafkaSubscriber]bool),
+	}
+}
+
+//SyncSend 记录消息及 headers, partition 固定为 0
+func (m *MemKafka) SyncSend(ctx context.Context, topic string, msg KafkaMsg, opts ...KafkaSendOpt) (partition int32, offset int64, err error) {
+	value, err := msg.Encode()
+	if nil != err {
+		return
+	}
+
+	opt := firstSendOpt(opts)
+	record := MemKafkaMessage{
+		Topic:     strings.TrimSpace(topic),
+		Value:     value,
+		Headers:   kafkaHeaders(ctx, opt),
+		Timestamp: opt.Timestamp,
+	}
+	if len(opt.Key) > 0 {
+		record.Key = []byte(opt.Key)
+	}
+	if record.Timestamp.IsZero() {
+		record.Timestamp = time.Now()
+	}
+
+	offset = m.record(record)
+	return
+}
+
+//ASyncSend 记录消息
+func (m *MemKafka) ASyncSend(ctx context.Context, topic string, msg KafkaMsg, opts ...KafkaSendOpt) {
+	m.SyncSend(ctx, topic, msg, opts...)
+}
+
+//ASyncSendReport 记录消息, 返回已完成的 KafkaDelivery
+func (m *MemKafka) ASyncSendReport(ctx context.Context, topic string, msg KafkaMsg, opts ...KafkaSendOpt) *KafkaDelivery {
+	delivery := newKafkaDelivery(strings.TrimSpace(topic))
+	partition, offset, err := m.SyncSend(ctx, topic, msg, opts...)
+	delivery.complete(partition, offset, err)
+	return delivery
+}
+
+//SyncSendMessage 记录消息, partition 固定为 0
+func (m *MemKafka) SyncSendMessage(topic string, msg KafkaMsg, key ...string) (partition int32, offset int64, err error) {
+	return m.SyncSend(context.Background(), topic, msg, sendOpt(key))
+}
+
+//ASyncSendMessage 记录消息
+func (m *MemKafka) ASyncSendMessage(topic string, msg KafkaMsg, key ...string) {
+	m.SyncSendMessage(topic, msg, key...)
+}
+
+//Subscribe 注册订阅并阻塞, 直到 ctx 取消
+func (m *MemKafka) Subscribe(ctx context.Context, topics []string, group string,
+	onMessage func(topic string, partition int32, offset int64, key, value []byte) error,
+	opts ...KafkaConsumerOpt) error {
+
+	return m.SubscribeMessage(ctx, topics, group, func(ctx context.Context, msg *KafkaMessage) error {
+		return onMessage(msg.Topic, msg.Partition, msg.Offset, msg.Key, msg.Value)
+	}, opts...)
+}
+
+//SubscribeMessage 注册订阅并阻塞, 直到 ctx 取消
+func (m *MemKafka) SubscribeMessage(ctx context.Context, topics []string, group string,
+	onMessage func(ctx context.Context, msg *KafkaMessage) error,
+	opts ...KafkaConsumerOpt) error {
+
+	sub := &memKafkaSubscriber{
+		topics:    make(map[string]bool),
+		group:     group,
+		onMessage: onMessage,
+	}
+	for _, topic := range topics {
+		sub.topics[strings.TrimSpace(topic)] = true
+	}
+
+	m.mutex.Lock()
+	m.subscribers[sub] = true
+	m.mutex.Unlock()
+
+	<-ctx.Done()
+
+	m.mutex.Lock()
+	delete(m.subscribers, sub)
+	m.mutex.Unlock()
+	return nil
+}
+
+//SubscribeBatch 注册订阅并阻塞, 直到 ctx 取消; 每次 Deliver 作为一批投递
+func (m *MemKafka) SubscribeBatch(ctx context.Context, topics []string, group string, batch KafkaBatchOpt,
+	onBatch func(ctx context.Context, msgs []*KafkaMessage) error,
+	opts ...KafkaConsumerOpt) error {
+
+	return m.SubscribeMessage(ctx, topics, group, func(ctx context.Context, msg *KafkaMessage) error {
+		return onBatch(ctx, []*KafkaMessage{msg})
+	}, opts...)
+}
+
+//Subscription 注册订阅并一直阻塞
+func (m *MemKafka) Subscription(topics []string, group string,
+	onMessage func(topic string, partition int32, offset int64, key, value []byte) error) {
+	m.Subscribe(context.Background(), topics, group, onMessage)
+}
+
+//MwKafka Kafka middleware
+func (m *MemKafka) MwKafka(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Set("kafka", m)
+		return next(c)
+	}
+}
+
+//Close *
+func (m *MemKafka) Close() error {
+	return nil
+}
+
+//Messages 已发送到 topic 的消息
+func (m *MemKafka) Messages(topic string) []MemKafkaMessage {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]MemKafkaMessage(nil), m.messages[strings.TrimSpace(topic)]...)
+}
+
+//DecodeMessages 已发送到 topic 的 KafkaMsg
+func (m *MemKafka) DecodeMessages(topic string) ([]KafkaMsg, error) {
+	messages := m.Messages(topic)
+	data := make([]KafkaMsg, 0, len(messages))
+	for _, msg := range messages {
+		var kafkaMsg KafkaMsg
+		if err := json.Unmarshal(msg.Value, &kafkaMsg); nil != err {
+			return nil, err
+		}
+		data = append(data, kafkaMsg)
+	}
+	return data, nil
+}
+
+//Reset 清空已记录的消息
+func (m *MemKafka) Reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.messages = make(map[string][]MemKafkaMessage)
+}
+
+//Subscribers 订阅了 topic 的消费组数量
+func (m *MemKafka) Subscribers(topic string) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	groups := make(map[string]bool)
+	for sub := range m.subscribers {
+		if sub.topics[strings.TrimSpace(topic)] {
+			groups[sub.group] = true
+		}
+	}
+	return len(groups)
+}
+
+//Deliver 同步投递到订阅了 topic 的消费者, 每个消费组投递一次, 返回第一个回调错误
+//投递的消息不会出现在 Messages 中
+func (m *MemKafka) Deliver(topic string, key, value []byte) error {
+	return m.DeliverMessage(context.Background(), &KafkaMessage{
+		Topic: topic,
+		Key:   key,
+		Value: value,
+	})
+}
+
+//DeliverMessage 同 Deliver, 可指定 headers 及时间戳; Offset 由 MemKafka 分配
+func (m *MemKafka) DeliverMessage(ctx context.Context, msg *KafkaMessage) error {
+	topic := strings.TrimSpace(msg.Topic)
+
+	m.mutex.Lock()
+	offset := m.offsets[topic]
+	m.offsets[topic]++
+	handlers := make([]*memKafkaSubscriber, 0)
+	groups := make(map[string]bool)
+	for sub := range m.subscribers {
+		if sub.topics[topic] && !groups[sub.group] {
+			groups[sub.group] = true
+			handlers = append(handlers, sub)
+		}
+	}
+	m.mutex.Unlock()
+
+	var err error
+	for _, sub := range handlers {
+		delivered := *msg
+		delivered.Topic = topic
+		delivered.Offset = offset
+		if nil == delivered.Headers {
+			delivered.Headers = make(map[string]string)
+		}
+		if delivered.Timestamp.IsZero() {
+			delivered.Timestamp = time.Now()
+		}
+		if herr := sub.onMessage(delivered.Context(ctx), &delivered); nil != herr && nil == err {
+			err = herr
+		}
+	}
+	return err
+}
+
+//DeliverMsg 编码 KafkaMsg 后投递
+func (m *MemKafka) DeliverMsg(topic string, msg KafkaMsg, key ...string) error {
+	value, err := msg.Encode()
+	if nil != err {
+		return err
+	}
+	var keyBytes []byte
+	if len(key) > 0 && len(key[0]) > 0 {
+		keyBytes = []byte(key[0])
+	}
+	return m.Deliver(topic, keyBytes, value)
+}
+
+func (m *MemKafka) record(msg MemKafkaMessage) int64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	msg.Offset = m.offsets[msg.Topic]
+	m.offsets[msg.Topic]++
+	m.messages[msg.Topic] = append(m.messages[msg.Topic], msg)
+	return msg.Offset
+}
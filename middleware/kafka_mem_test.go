@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemKafkaSend(t *testing.T) {
+	m := NewMemKafka()
+	ctx := WithKafkaTrace(context.Background(), KafkaTrace{RequestID: "req-1"})
+
+	msg := KafkaMsg{Receiver: []string{"u1"}, Data: "hello"}
+	if _, offset, err := m.SyncSend(ctx, " notify ", msg, KafkaSendOpt{Key: "k1"}); nil != err || offset != 0 {
+		t.Fatalf("SyncSend() offset = %d, err = %v", offset, err)
+	}
+	m.ASyncSendMessage("notify", msg)
+
+	messages := m.Messages("notify")
+	if len(messages) != 2 {
+		t.Fatalf("Messages() len = %d, want 2", len(messages))
+	}
+	if messages[0].Offset != 0 || messages[1].Offset != 1 {
+		t.Errorf("Messages() offsets = %d, %d, want 0, 1", messages[0].Offset, messages[1].Offset)
+	}
+	if string(messages[0].Key) != "k1" {
+		t.Errorf("Messages()[0].Key = %q, want k1", messages[0].Key)
+	}
+	if messages[0].Headers[KAFKA_HEADER_REQUEST_ID] != "req-1" {
+		t.Errorf("Messages()[0] request id = %q, want req-1", messages[0].Headers[KAFKA_HEADER_REQUEST_ID])
+	}
+	if len(messages[0].Headers[KAFKA_HEADER_MESSAGE_ID]) == 0 {
+		t.Error("Messages()[0] missing message id")
+	}
+
+	decoded, err := m.DecodeMessages("notify")
+	if nil != err || len(decoded) != 2 || decoded[0].Receiver[0] != "u1" {
+		t.Fatalf("DecodeMessages() = %v, %v", decoded, err)
+	}
+
+	if _, offset, err := m.ASyncSendReport(ctx, "notify", msg).Wait(ctx); nil != err || offset != 2 {
+		t.Errorf("ASyncSendReport() offset = %d, err = %v", offset, err)
+	}
+
+	m.Reset()
+	if len(m.Messages("notify")) != 0 {
+		t.Error("Reset() did not clear messages")
+	}
+}
+
+func TestMemKafkaDeliver(t *testing.T) {
+	m := NewMemKafka()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received := make(chan *KafkaMessage, 10)
+	subscribe := func(group string, err error) {
+		go m.SubscribeMessage(ctx, []string{"notify"}, group, func(ctx context.Context, msg *KafkaMessage) error {
+			if KafkaTraceFromContext(ctx).RequestID != msg.Header(KAFKA_HEADER_REQUEST_ID) {
+				t.Errorf("trace context not propagated")
+			}
+			received <- msg
+			return err
+		})
+	}
+	subscribe("g1", nil)
+	subscribe("g1", nil)
+	subscribe("g2", errors.New("failed"))
+
+	deadline := time.Now().Add(time.Second)
+	for m.Subscribers("notify") != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Subscribers() = %d, want 2", m.Subscribers("notify"))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	err := m.DeliverMessage(context.Background(), &KafkaMessage{
+		Topic:   "notify",
+		Value:   []byte(`{}`),
+		Headers: map[string]string{KAFKA_HEADER_REQUEST_ID: "req-2"},
+	})
+	if nil == err {
+		t.Error("DeliverMessage() error = nil, want handler error")
+	}
+	if len(received) != 2 {
+		t.Fatalf("delivered %d times, want once per group", len(received))
+	}
+
+	if err := m.Deliver("other", nil, []byte(`{}`)); nil != err {
+		t.Errorf("Deliver() to topic without subscribers error = %v", err)
+	}
+	if len(m.Messages("notify")) != 0 {
+		t.Error("delivered messages should not be recorded")
+	}
+
+	cancel()
+	deadline = time.Now().Add(time.Second)
+	for m.Subscribers("notify") != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("subscribers not removed after cancel")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/GreatSir/realclouds_go/utils"
+	"github.com/Shopify/sarama"
+)
+
+const (
+	//KAFKA_HEADER_MESSAGE_ID 消息 ID, 用于消费端去重
+	KAFKA_HEADER_MESSAGE_ID = "message-id"
+	//KAFKA_HEADER_REQUEST_ID *
+	KAFKA_HEADER_REQUEST_ID = "X-Request-ID"
+	//KAFKA_HEADER_TRACEPARENT W3C trace context
+	KAFKA_HEADER_TRACEPARENT = "traceparent"
+	//KAFKA_HEADER_TRACESTATE W3C trace context
+	KAFKA_HEADER_TRACESTATE = "tracestate"
+	//KAFKA_HEADER_CONTENT_TYPE *
+	KAFKA_HEADER_CONTENT_TYPE = "content-type"
+	//KAFKA_HEADER_SCHEMA_VERSION *
+	KAFKA_HEADER_SCHEMA_VERSION = "schema-version"
+
+	//KAFKA_MSG_CONTENT_TYPE KafkaMsg 的 content-type
+	KAFKA_MSG_CONTENT_TYPE = "application/json"
+	//KAFKA_MSG_SCHEMA_VERSION KafkaMsg 的 schema 版本
+	KAFKA_MSG_SCHEMA_VERSION = "1"
+)
+
+type kafkaTraceKey struct{}
+
+//KafkaTrace 随消息传递的请求 ID 及 trace context
+type KafkaTrace struct {
+	RequestID   string
+	TraceParent string
+	TraceState  string
+}
+
+//WithKafkaTrace *
+func WithKafkaTrace(ctx context.Context, trace KafkaTrace) context.Context {
+	return context.WithValue(ctx, kafkaTraceKey{}, trace)
+}
+
+//KafkaTraceFromContext *
+func KafkaTraceFromContext(ctx context.Context) KafkaTrace {
+	if nil == ctx {
+		return KafkaTrace{}
+	}
+	trace, _ := ctx.Value(kafkaTraceKey{}).(KafkaTrace)
+	return trace
+}
+
+//KafkaSendOpt 发送参数
+type KafkaSendOpt struct {
+	Key       string
+	Headers   map[string]string
+	Timestamp time.Time
+}
+
+//KafkaMessage 消费到的消息
+type KafkaMessage struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   map[string]string
+	Timestamp time.Time
+}
+
+//Header *
+func (m *KafkaMessage) Header(key string) string {
+	return m.Headers[key]
+}
+
+//Decode 解码为 KafkaMsg
+func (m *KafkaMessage) Decode() (msg KafkaMsg, err error) {
+	err = json.Unmarshal(m.Value, &msg)
+	return
+}
+
+//Context 将消息中的 trace context 放入 ctx
+func (m *KafkaMessage) Context(ctx context.Context) context.Context {
+	return WithKafkaTrace(ctx, KafkaTrace{
+		RequestID:   m.Headers[KAFKA_HEADER_REQUEST_ID],
+		TraceParent: m.Headers[KAFKA_HEADER_TRACEPARENT],
+		TraceState:  m.Headers[KAFKA_HEADER_TRACESTATE],
+	})
+}
+
+//kafkaHeaders 合并默认 headers, ctx 中的 trace context 及 opt.Headers
+func kafkaHeaders(ctx context.Context, opt KafkaSendOpt) map[string]string {
+	headers := map[string]string{
+		KAFKA_HEADER_MESSAGE_ID:     utils.GenerateUUID(),
+		KAFKA_HEADER_CONTENT_TYPE:   KAFKA_MSG_CONTENT_TYPE,
+		KAFKA_HEADER_SCHEMA_VERSION: KAFKA_MSG_SCHEMA_VERSION,
+	}
+
+	trace := KafkaTraceFromContext(ctx)
+	for k, v := range map[string]string{
+		KAFKA_HEADER_REQUEST_ID:  trace.RequestID,
+		KAFKA_HEADER_TRACEPARENT: trace.TraceParent,
+		KAFKA_HEADER_TRACESTATE:  trace.TraceState,
+	} {
+		if len(v) != 0 {
+			headers[k] = v
+		}
+	}
+
+	for k, v := range opt.Headers {
+		headers[k] = v
+	}
+	return headers
+}
+
+//newProducerMessage headers 为 false 时不添加 record headers (Kafka 0.11 以下版本)
+func newProducerMessage(ctx context.Context, topic string, msg *KafkaMsg, opt KafkaSendOpt, headers bool) *sarama.ProducerMessage {
+	producerMessage := &sarama.ProducerMessage{
+		Topic:     strings.TrimSpace(topic),
+		Value:     msg,
+		Timestamp: opt.Timestamp,
+	}
+	if producerMessage.Timestamp.IsZero() {
+		producerMessage.Timestamp = time.Now()
+	}
+
+	if len(opt.Key) > 0 {
+		producerMessage.Key = sarama.StringEncoder(opt.Key)
+	}
+
+	if !headers {
+		return producerMessage
+	}
+	for k, v := range kafkaHeaders(ctx, opt) {
+		producerMessage.Headers = append(producerMessage.Headers, sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+	}
+	return producerMessage
+}
+
+func newKafkaMessage(msg *sarama.ConsumerMessage) *KafkaMessage {
+	m := &KafkaMessage{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Headers:   make(map[string]string, len(msg.Headers)),
+		Timestamp: msg.Timestamp,
+	}
+	for _, h := range msg.Headers {
+		m.Headers[string(h.Key)] = string(h.Value)
+	}
+	return m
+}
+
+func sendOpt(key []string) KafkaSendOpt {
+	if len(key) > 0 {
+		return KafkaSendOpt{Key: key[0]}
+	}
+	return KafkaSendOpt{}
+}
+
+func firstSendOpt(opts []KafkaSendOpt) KafkaSendOpt {
+	if len(opts) > 0 {
+		return opts[0]
+	}
+	return KafkaSendOpt{}
+}
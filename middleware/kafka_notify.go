@@ -0,0 +1,482 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//NotifyScope 消息范围, 对应 KafkaDuMessage.Type
+type NotifyScope int
+
+//NotifyKind 消息类别, 对应 KafkaDuMessage.Type2
+type NotifyKind int
+
+//NotifyResource 资源类型, 对应 KafkaDuMessage.Type3
+type NotifyResource int
+
+//NotifyAction 动作/结果, 对应 KafkaDuMessage.Type4
+type NotifyAction int
+
+const (
+	//NotifyPublic 公共消息
+	NotifyPublic NotifyScope = 1
+	//NotifyPrivate 私有消息
+	NotifyPrivate NotifyScope = 2
+)
+
+const (
+	NotifyKindComment   NotifyKind = 1  //评论
+	NotifyKindReply     NotifyKind = 2  //回复
+	NotifyKindLike      NotifyKind = 3  //点赞
+	NotifyKindFollow    NotifyKind = 4  //关注
+	NotifyKindPodcast   NotifyKind = 5  //播客
+	NotifyKindTakedown  NotifyKind = 6  //下架
+	NotifyKindAudit     NotifyKind = 7  //审核
+	NotifyKindCoins     NotifyKind = 8  //金币
+	NotifyKindLove      NotifyKind = 9  //爱心值
+	NotifyKindRecommend NotifyKind = 10 //推荐
+	NotifyKindActivity  NotifyKind = 11 //活动
+	NotifyKindAudio     NotifyKind = 12 //音频
+	NotifyKindText      NotifyKind = 13 //文本
+	NotifyKindAlbum     NotifyKind = 14 //专辑
+)
+
+const (
+	NotifyResourceGeneral NotifyResource = 0 //通用
+	NotifyResourceAudio   NotifyResource = 1 //音频
+	NotifyResourceText    NotifyResource = 2 //文本
+	NotifyResourceAlbum   NotifyResource = 3 //专辑
+	NotifyResourceComment NotifyResource = 4 //评论
+)
+
+const (
+	NotifyReceived  NotifyAction = 1  //收到
+	NotifySent      NotifyAction = 2  //发送
+	NotifyAdded     NotifyAction = 3  //新增
+	NotifyUpdated   NotifyAction = 4  //更新
+	NotifyIncome    NotifyAction = 5  //收入
+	NotifyExpense   NotifyAction = 6  //支出
+	NotifyOnline    NotifyAction = 7  //线上
+	NotifyOffline   NotifyAction = 8  //线下
+	NotifyUpgrade   NotifyAction = 9  //升级
+	NotifyDowngrade NotifyAction = 10 //降级
+	NotifyApproved  NotifyAction = 11 //通过
+	NotifyRejected  NotifyAction = 12 //驳回
+	NotifyPending   NotifyAction = 13 //审核中
+	NotifyHome      NotifyAction = 14 //首页
+	NotifyBanner    NotifyAction = 15 //横幅
+)
+
+var notifyScopeNames = map[NotifyScope]string{
+	NotifyPublic:  "public",
+	NotifyPrivate: "private",
+}
+
+var notifyKindNames = map[NotifyKind]string{
+	NotifyKindComment:   "comment",
+	NotifyKindReply:     "reply",
+	NotifyKindLike:      "like",
+	NotifyKindFollow:    "follow",
+	NotifyKindPodcast:   "podcast",
+	NotifyKindTakedown:  "takedown",
+	NotifyKindAudit:     "audit",
+	NotifyKindCoins:     "coins",
+	NotifyKindLove:      "love",
+	NotifyKindRecommend: "recommend",
+	NotifyKindActivity:  "activity",
+	NotifyKindAudio:     "audio",
+	NotifyKindText:      "text",
+	NotifyKindAlbum:     "album",
+}
+
+var notifyResourceNames = map[NotifyResource]string{
+	NotifyResourceGeneral: "general",
+	NotifyResourceAudio:   "audio",
+	NotifyResourceText:    "text",
+	NotifyResourceAlbum:   "album",
+	NotifyResourceComment: "comment",
+}
+
+var notifyActionNames = map[NotifyAction]string{
+	NotifyReceived:  "received",
+	NotifySent:      "sent",
+	NotifyAdded:     "added",
+	NotifyUpdated:   "updated",
+	NotifyIncome:    "income",
+	NotifyExpense:   "expense",
+	NotifyOnline:    "online",
+	NotifyOffline:   "offline",
+	NotifyUpgrade:   "upgrade",
+	NotifyDowngrade: "downgrade",
+	NotifyApproved:  "approved",
+	NotifyRejected:  "rejected",
+	NotifyPending:   "pending",
+	NotifyHome:      "home",
+	NotifyBanner:    "banner",
+}
+
+//String *
+func (s NotifyScope) String() string {
+	if name, ok := notifyScopeNames[s]; ok {
+		return name
+	}
+	return strconv.Itoa(int(s))
+}
+
+//String *
+func (k NotifyKind) String() string {
+	if name, ok := notifyKindNames[k]; ok {
+		return name
+	}
+	return strconv.Itoa(int(k))
+}
+
+//String *
+func (r NotifyResource) String() string {
+	if name, ok := notifyResourceNames[r]; ok {
+		return name
+	}
+	return strconv.Itoa(int(r))
+}
+
+//String *
+func (a NotifyAction) String() string {
+	if name, ok := notifyActionNames[a]; ok {
+		return name
+	}
+	return strconv.Itoa(int(a))
+}
+
+//Validate 校验 Type..Type4 取值是否在定义的范围内
+func (m *KafkaDuMessage) Validate() error {
+	if _, ok := notifyScopeNames[m.Type]; !ok {
+		return fmt.Errorf("Invalid notify scope: %d.", m.Type)
+	}
+	if _, ok := notifyKindNames[m.Type2]; !ok {
+		return fmt.Errorf("Invalid notify kind: %d.", m.Type2)
+	}
+	if _, ok := notifyResourceNames[m.Type3]; !ok {
+		return fmt.Errorf("Invalid notify resource: %d.", m.Type3)
+	}
+	if _, ok := notifyActionNames[m.Type4]; !ok {
+		return fmt.Errorf("Invalid notify action: %d.", m.Type4)
+	}
+	return nil
+}
+
+//Public 设置为公共消息
+func (m KafkaDuMessage) Public() KafkaDuMessage {
+	m.Type = NotifyPublic
+	return m
+}
+
+//NewKafkaMsg 校验后生成 KafkaMsg, 私有消息必须指定接收者, 接收者不能为空字符串
+func NewKafkaMsg(message KafkaDuMessage, receiver ...string) (msg KafkaMsg, err error) {
+	if err = message.Validate(); nil != err {
+		return
+	}
+	if message.Type == NotifyPrivate && len(receiver) == 0 {
+		err = fmt.Errorf("%s", "Private notify requires receiver.")
+		return
+	}
+	for _, r := range receiver {
+		if len(strings.TrimSpace(r)) == 0 {
+			err = fmt.Errorf("%s", "Notify receiver is empty.")
+			return
+		}
+	}
+
+	msg = KafkaMsg{
+		Receiver: receiver,
+		Message:  message,
+	}
+	if nil == msg.Receiver {
+		msg.Receiver = []string{}
+	}
+	return
+}
+
+//notifyContentResources 内容类资源
+var notifyContentResources = []NotifyResource{NotifyResourceAudio, NotifyResourceText, NotifyResourceAlbum}
+
+//notifyTargetResources 可被点赞/下架/审核的资源
+var notifyTargetResources = []NotifyResource{NotifyResourceAudio, NotifyResourceText, NotifyResourceAlbum, NotifyResourceComment}
+
+//notifyContentKinds 内容资源对应的消息类别
+var notifyContentKinds = map[NotifyResource]NotifyKind{
+	NotifyResourceAudio: NotifyKindAudio,
+	NotifyResourceText:  NotifyKindText,
+	NotifyResourceAlbum: NotifyKindAlbum,
+}
+
+func checkNotifyResource(kind NotifyKind, resource NotifyResource, allowed ...NotifyResource) error {
+	for _, r := range allowed {
+		if r == resource {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unsupported resource %s for %s notify.", resource, kind)
+}
+
+func checkNotifyAction(kind NotifyKind, action NotifyAction, allowed ...NotifyAction) error {
+	for _, a := range allowed {
+		if a == action {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unsupported action %s for %s notify.", action, kind)
+}
+
+//NewCommentNotify 评论(音频/文本/专辑): ResourceID 被评论资源, ResourceID2 评论, ResourceID3 评论人, Value 评论内容
+func NewCommentNotify(resource NotifyResource, resourceID, commentID, userID, content string) (message KafkaDuMessage, err error) {
+	if err = checkNotifyResource(NotifyKindComment, resource, notifyContentResources...); nil != err {
+		return
+	}
+	message = KafkaDuMessage{
+		Type:        NotifyPrivate,
+		Type2:       NotifyKindComment,
+		Type3:       resource,
+		Type4:       NotifyReceived,
+		ResourceID:  resourceID,
+		ResourceID2: commentID,
+		ResourceID3: userID,
+		Value:       content,
+	}
+	return
+}
+
+//NewReplyNotify 回复(音频/文本/专辑): ResourceID 资源, ResourceID2 被回复评论, ResourceID3 回复人, ResourceID4 回复, Value 回复内容
+func NewReplyNotify(resource NotifyResource, resourceID, commentID, userID, replyID, content string) (message KafkaDuMessage, err error) {
+	if err = checkNotifyResource(NotifyKindReply, resource, notifyContentResources...); nil != err {
+		return
+	}
+	message = KafkaDuMessage{
+		Type:        NotifyPrivate,
+		Type2:       NotifyKindReply,
+		Type3:       resource,
+		Type4:       NotifyReceived,
+		ResourceID:  resourceID,
+		ResourceID2: commentID,
+		ResourceID3: userID,
+		ResourceID4: replyID,
+		Value:       content,
+	}
+	return
+}
+
+//NewLikeNotify 点赞(音频/文本/专辑/评论): ResourceID 被点赞资源, ResourceID3 点赞人
+func NewLikeNotify(resource NotifyResource, resourceID, userID string) (message KafkaDuMessage, err error) {
+	if err = checkNotifyResource(NotifyKindLike, resource, notifyTargetResources...); nil != err {
+		return
+	}
+	message = KafkaDuMessage{
+		Type:        NotifyPrivate,
+		Type2:       NotifyKindLike,
+		Type3:       resource,
+		Type4:       NotifyReceived,
+		ResourceID:  resourceID,
+		ResourceID3: userID,
+	}
+	return
+}
+
+//NewFollowNotify 关注: ResourceID3 关注人
+func NewFollowNotify(userID string) KafkaDuMessage {
+	return KafkaDuMessage{
+		Type:        NotifyPrivate,
+		Type2:       NotifyKindFollow,
+		Type3:       NotifyResourceGeneral,
+		Type4:       NotifyReceived,
+		ResourceID3: userID,
+	}
+}
+
+//NewTakedownNotify 下架(音频/文本/专辑/评论): ResourceID 被下架资源, Value 原因
+func NewTakedownNotify(resource NotifyResource, resourceID, reason string) (message KafkaDuMessage, err error) {
+	if err = checkNotifyResource(NotifyKindTakedown, resource, notifyTargetResources...); nil != err {
+		return
+	}
+	message = KafkaDuMessage{
+		Type:       NotifyPrivate,
+		Type2:      NotifyKindTakedown,
+		Type3:      resource,
+		Type4:      NotifyReceived,
+		ResourceID: resourceID,
+		Value:      reason,
+	}
+	return
+}
+
+//NewAuditNotify 审核结果(NotifyApproved/NotifyRejected/NotifyPending), 音频/文本/专辑/评论: ResourceID 资源, Value 原因
+func NewAuditNotify(resource NotifyResource, resourceID string, result NotifyAction, reason string) (message KafkaDuMessage, err error) {
+	if err = checkNotifyResource(NotifyKindAudit, resource, notifyTargetResources...); nil != err {
+		return
+	}
+	if err = checkNotifyAction(NotifyKindAudit, result, NotifyApproved, NotifyRejected, NotifyPending); nil != err {
+		return
+	}
+	message = KafkaDuMessage{
+		Type:       NotifyPrivate,
+		Type2:      NotifyKindAudit,
+		Type3:      resource,
+		Type4:      result,
+		ResourceID: resourceID,
+		Value:      reason,
+	}
+	return
+}
+
+//NewCoinsNotify 金币收支(NotifyIncome/NotifyExpense): Value 数量, Value2 原因
+func NewCoinsNotify(action NotifyAction, amount int64, reason string) (message KafkaDuMessage, err error) {
+	if err = checkNotifyAction(NotifyKindCoins, action, NotifyIncome, NotifyExpense); nil != err {
+		return
+	}
+	message = KafkaDuMessage{
+		Type:   NotifyPrivate,
+		Type2:  NotifyKindCoins,
+		Type3:  NotifyResourceGeneral,
+		Type4:  action,
+		Value:  strconv.FormatInt(amount, 10),
+		Value2: reason,
+	}
+	return
+}
+
+//NewLoveNotify 爱心值变化(NotifyIncome/NotifyExpense/NotifyUpgrade/NotifyDowngrade): Value 数量或等级, Value2 原因
+func NewLoveNotify(action NotifyAction, amount int64, reason string) (message KafkaDuMessage, err error) {
+	if err = checkNotifyAction(NotifyKindLove, action, NotifyIncome, NotifyExpense, NotifyUpgrade, NotifyDowngrade); nil != err {
+		return
+	}
+	message = KafkaDuMessage{
+		Type:   NotifyPrivate,
+		Type2:  NotifyKindLove,
+		Type3:  NotifyResourceGeneral,
+		Type4:  action,
+		Value:  strconv.FormatInt(amount, 10),
+		Value2: reason,
+	}
+	return
+}
+
+//NewRecommendNotify 推荐(NotifyHome/NotifyBanner), 音频/文本/专辑: ResourceID 被推荐资源
+func NewRecommendNotify(resource NotifyResource, resourceID string, position NotifyAction) (message KafkaDuMessage, err error) {
+	if err = checkNotifyResource(NotifyKindRecommend, resource, notifyContentResources...); nil != err {
+		return
+	}
+	if err = checkNotifyAction(NotifyKindRecommend, position, NotifyHome, NotifyBanner); nil != err {
+		return
+	}
+	message = KafkaDuMessage{
+		Type:       NotifyPrivate,
+		Type2:      NotifyKindRecommend,
+		Type3:      resource,
+		Type4:      position,
+		ResourceID: resourceID,
+	}
+	return
+}
+
+//NewActivityNotify 活动(NotifyOnline/NotifyOffline), 公共消息: ResourceID 活动, Value 标题
+func NewActivityNotify(activityID string, action NotifyAction, title string) (message KafkaDuMessage, err error) {
+	if err = checkNotifyAction(NotifyKindActivity, action, NotifyOnline, NotifyOffline); nil != err {
+		return
+	}
+	message = KafkaDuMessage{
+		Type:       NotifyPublic,
+		Type2:      NotifyKindActivity,
+		Type3:      NotifyResourceGeneral,
+		Type4:      action,
+		ResourceID: activityID,
+		Value:      title,
+	}
+	return
+}
+
+//NewContentNotify 音频/文本/专辑新增或更新(NotifyAdded/NotifyUpdated): ResourceID 资源, Value 标题
+func NewContentNotify(resource NotifyResource, resourceID string, action NotifyAction, title string) (message KafkaDuMessage, err error) {
+	kind, ok := notifyContentKinds[resource]
+	if !ok {
+		err = fmt.Errorf("Unsupported resource %s for content notify.", resource)
+		return
+	}
+	if err = checkNotifyAction(kind, action, NotifyAdded, NotifyUpdated); nil != err {
+		return
+	}
+	message = KafkaDuMessage{
+		Type:       NotifyPrivate,
+		Type2:      kind,
+		Type3:      resource,
+		Type4:      action,
+		ResourceID: resourceID,
+		Value:      title,
+	}
+	return
+}
+
+//KafkaMsgSchema KafkaMsg 的 JSON Schema, 版本同 KAFKA_MSG_SCHEMA_VERSION
+func KafkaMsgSchema() ([]byte, error) {
+	str := map[string]interface{}{"type": "string"}
+
+	message := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"type":          notifySchemaEnum(notifyScopeNames),
+			"type_2":        notifySchemaEnum(notifyKindNames),
+			"type_3":        notifySchemaEnum(notifyResourceNames),
+			"type_4":        notifySchemaEnum(notifyActionNames),
+			"type_5":        map[string]interface{}{"type": "integer"},
+			"type_6":        map[string]interface{}{"type": "integer"},
+			"resource_id":   str,
+			"resource_id_2": str,
+			"resource_id_3": str,
+			"resource_id_4": str,
+			"resource_id_5": str,
+			"resource_id_6": str,
+			"value":         str,
+			"value_2":       str,
+			"value_3":       str,
+			"value_4":       str,
+			"value_5":       str,
+			"value_6":       str,
+			"read":          map[string]interface{}{"type": "boolean"},
+			"state":         map[string]interface{}{"type": "integer"},
+		},
+		"required": []string{"type", "type_2", "type_3", "type_4"},
+	}
+
+	schema := map[string]interface{}{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"$id":     "kafka-msg/v" + KAFKA_MSG_SCHEMA_VERSION,
+		"title":   "KafkaMsg",
+		"type":    "object",
+		"properties": map[string]interface{}{
+			"receiver": map[string]interface{}{"type": "array", "items": str},
+			"message":  message,
+			"data":     map[string]interface{}{},
+		},
+		"required": []string{"receiver", "message"},
+	}
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+func notifySchemaEnum[T ~int](names map[T]string) map[string]interface{} {
+	values := make([]int, 0, len(names))
+	for v := range names {
+		values = append(values, int(v))
+	}
+	sort.Ints(values)
+
+	descs := make([]string, 0, len(values))
+	for _, v := range values {
+		descs = append(descs, fmt.Sprintf("%d-%s", v, names[T(v)]))
+	}
+
+	return map[string]interface{}{
+		"type":        "integer",
+		"enum":        values,
+		"description": strings.Join(descs, " "),
+	}
+}
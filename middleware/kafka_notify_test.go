@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"testing"
+)
+
+func TestNotifyConstructors(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func() (KafkaDuMessage, error)
+		kind NotifyKind
+		err  bool
+	}{
+		{"comment", func() (KafkaDuMessage, error) { return NewCommentNotify(NotifyResourceAudio, "r", "c", "u", "x") }, NotifyKindComment, false},
+		{"comment on general", func() (KafkaDuMessage, error) { return NewCommentNotify(NotifyResourceGeneral, "r", "c", "u", "x") }, 0, true},
+		{"reply on comment", func() (KafkaDuMessage, error) { return NewReplyNotify(NotifyResourceComment, "r", "c", "u", "p", "x") }, 0, true},
+		{"like comment", func() (KafkaDuMessage, error) { return NewLikeNotify(NotifyResourceComment, "r", "u") }, NotifyKindLike, false},
+		{"like invalid resource", func() (KafkaDuMessage, error) { return NewLikeNotify(NotifyResource(9), "r", "u") }, 0, true},
+		{"takedown general", func() (KafkaDuMessage, error) { return NewTakedownNotify(NotifyResourceGeneral, "r", "x") }, 0, true},
+		{"audit approved", func() (KafkaDuMessage, error) { return NewAuditNotify(NotifyResourceText, "r", NotifyApproved, "") }, NotifyKindAudit, false},
+		{"audit with income", func() (KafkaDuMessage, error) { return NewAuditNotify(NotifyResourceText, "r", NotifyIncome, "") }, 0, true},
+		{"audit general", func() (KafkaDuMessage, error) { return NewAuditNotify(NotifyResourceGeneral, "r", NotifyRejected, "") }, 0, true},
+		{"coins expense", func() (KafkaDuMessage, error) { return NewCoinsNotify(NotifyExpense, 10, "x") }, NotifyKindCoins, false},
+		{"coins upgrade", func() (KafkaDuMessage, error) { return NewCoinsNotify(NotifyUpgrade, 10, "x") }, 0, true},
+		{"love downgrade", func() (KafkaDuMessage, error) { return NewLoveNotify(NotifyDowngrade, 1, "x") }, NotifyKindLove, false},
+		{"love approved", func() (KafkaDuMessage, error) { return NewLoveNotify(NotifyApproved, 1, "x") }, 0, true},
+		{"recommend banner", func() (KafkaDuMessage, error) { return NewRecommendNotify(NotifyResourceAlbum, "r", NotifyBanner) }, NotifyKindRecommend, false},
+		{"recommend online", func() (KafkaDuMessage, error) { return NewRecommendNotify(NotifyResourceAlbum, "r", NotifyOnline) }, 0, true},
+		{"recommend comment", func() (KafkaDuMessage, error) { return NewRecommendNotify(NotifyResourceComment, "r", NotifyHome) }, 0, true},
+		{"activity offline", func() (KafkaDuMessage, error) { return NewActivityNotify("a", NotifyOffline, "x") }, NotifyKindActivity, false},
+		{"activity home", func() (KafkaDuMessage, error) { return NewActivityNotify("a", NotifyHome, "x") }, 0, true},
+		{"content text", func() (KafkaDuMessage, error) { return NewContentNotify(NotifyResourceText, "r", NotifyUpdated, "x") }, NotifyKindText, false},
+		{"content comment", func() (KafkaDuMessage, error) { return NewContentNotify(NotifyResourceComment, "r", NotifyAdded, "x") }, 0, true},
+		{"content general", func() (KafkaDuMessage, error) { return NewContentNotify(NotifyResourceGeneral, "r", NotifyAdded, "x") }, 0, true},
+		{"content received", func() (KafkaDuMessage, error) { return NewContentNotify(NotifyResourceAudio, "r", NotifyReceived, "x") }, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, err := tt.fn()
+			if tt.err {
+				if nil == err {
+					t.Fatalf("error = nil, want error, message = %+v", message)
+				}
+				return
+			}
+			if nil != err {
+				t.Fatalf("error = %v", err)
+			}
+			if message.Type2 != tt.kind {
+				t.Errorf("Type2 = %s, want %s", message.Type2, tt.kind)
+			}
+			if err = message.Validate(); nil != err {
+				t.Errorf("Validate() error = %v", err)
+			}
+		})
+	}
+}
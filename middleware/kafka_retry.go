@@ -0,0 +1,237 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+//KafkaRetryTopic 延迟重试 topic
+type KafkaRetryTopic struct {
+	Topic string
+	Delay time.Duration
+}
+
+//KafkaRetryPolicy 重试策略: 先立即重试(指数退避), 再依次投递到延迟重试 topic, 最后投递到死信 topic
+//重试 topic 由同一个消费组一并订阅
+type KafkaRetryPolicy struct {
+	//Retries 立即重试次数, 小于 0 时视为 0
+	Retries         int
+	Backoff         time.Duration
+	MaxBackoff      time.Duration
+	RetryTopics     []KafkaRetryTopic
+	DeadLetterTopic string
+}
+
+//KafkaFailedMessage 重试及死信消息, 保存原始消息, 错误及已尝试次数
+type KafkaFailedMessage struct {
+	Topic     string            `json:"topic" xml:"topic"`
+	Partition int32             `json:"partition" xml:"partition"`
+	Offset    int64             `json:"offset" xml:"offset"`
+	Key       []byte            `json:"key,omitempty" xml:"key,omitempty"`
+	Value     []byte            `json:"value,omitempty" xml:"value,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty" xml:"headers,omitempty"`
+	Timestamp time.Time         `json:"timestamp,omitempty" xml:"timestamp,omitempty"`
+	Error     string            `json:"error" xml:"error"`
+	Attempts  int               `json:"attempts" xml:"attempts"`
+	Stage     int               `json:"stage" xml:"stage"`
+	RetryAt   time.Time         `json:"retry_at,omitempty" xml:"retry_at,omitempty"`
+	FailedAt  time.Time         `json:"failed_at" xml:"failed_at"`
+}
+
+func (p *KafkaRetryPolicy) topics() []string {
+	topics := make([]string, 0, len(p.RetryTopics))
+	for _, t := range p.RetryTopics {
+		topics = append(topics, t.Topic)
+	}
+	return topics
+}
+
+func (p *KafkaRetryPolicy) stage(topic string) int {
+	for i, t := range p.RetryTopics {
+		if t.Topic == topic {
+			return i
+		}
+	}
+	return -1
+}
+
+//wait 延迟重试 topic 中的消息等待到 RetryAt
+func (p *KafkaRetryPolicy) wait(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	if p.stage(msg.Topic) < 0 {
+		return nil
+	}
+
+	failed := &KafkaFailedMessage{}
+	if err := json.Unmarshal(msg.Value, failed); nil != err {
+		return nil
+	}
+	return sleepContext(ctx, time.Until(failed.RetryAt))
+}
+
+//retryHandler 包装 onMessage, 失败后按策略重试, 转发到重试或死信 topic 成功后视为处理完成
+func (k *Kafka) retryHandler(policy *KafkaRetryPolicy,
+	onMessage func(ctx context.Context, msg *sarama.ConsumerMessage) error) func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+
+	return func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		stage := policy.stage(msg.Topic)
+
+		var failed *KafkaFailedMessage
+		if stage < 0 {
+			failed = newKafkaFailedMessage(msg)
+		} else {
+			failed = &KafkaFailedMessage{}
+			if err := json.Unmarshal(msg.Value, failed); nil != err {
+				return err
+			}
+			msg = failed.message()
+		}
+
+		retries := policy.Retries
+		if retries < 0 {
+			retries = 0
+		}
+
+		backoff := policy.Backoff
+		var err error
+		for i := 0; i <= retries; i++ {
+			if i > 0 {
+				if err := sleepContext(ctx, backoff); nil != err {
+					return err
+				}
+				if backoff *= 2; policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+					backoff = policy.MaxBackoff
+				}
+			}
+
+			failed.Attempts++
+			if err = onMessage(ctx, msg); nil == err {
+				return nil
+			}
+			if nil != ctx.Err() {
+				return err
+			}
+		}
+
+		failed.Error = err.Error()
+		failed.FailedAt = time.Now()
+		return k.forwardFailed(failed, stage+1, policy)
+	}
+}
+
+//forwardFailed 转发到下一个重试 topic, 没有时转发到死信 topic
+func (k *Kafka) forwardFailed(failed *KafkaFailedMessage, stage int, policy *KafkaRetryPolicy) error {
+	topic := policy.DeadLetterTopic
+	failed.Stage = stage
+	failed.RetryAt = time.Time{}
+
+	if stage < len(policy.RetryTopics) {
+		topic = policy.RetryTopics[stage].Topic
+		failed.RetryAt = time.Now().Add(policy.RetryTopics[stage].Delay)
+	}
+
+	if len(topic) == 0 {
+		return errors.New(failed.Error)
+	}
+
+	b, err := json.Marshal(failed)
+	if nil != err {
+		return err
+	}
+
+	producerMessage := &sarama.ProducerMessage{
+		Topic: topic,
+		Value: sarama.ByteEncoder(b),
+	}
+	if len(failed.Key) > 0 {
+		producerMessage.Key = sarama.ByteEncoder(failed.Key)
+	}
+
+	_, _, err = k.SyncProducerCollector.SendMessage(producerMessage)
+	return err
+}
+
+//ReplayDeadLetters 消费死信 topic, 将原始消息(含 headers)重新发送到原 topic, filter 返回 false 时跳过
+//阻塞直到 ctx 取消
+func (k *Kafka) ReplayDeadLetters(ctx context.Context, deadLetterTopic, group string,
+	filter func(failed *KafkaFailedMessage) bool, opts ...KafkaConsumerOpt) error {
+
+	return k.Subscribe(ctx, []string{deadLetterTopic}, group,
+		func(topic string, partition int32, offset int64, key, value []byte) error {
+			failed := &KafkaFailedMessage{}
+			if err := json.Unmarshal(value, failed); nil != err {
+				return err
+			}
+			if nil != filter && !filter(failed) {
+				return nil
+			}
+
+			producerMessage := &sarama.ProducerMessage{
+				Topic:     failed.Topic,
+				Value:     sarama.ByteEncoder(failed.Value),
+				Timestamp: failed.Timestamp,
+			}
+			if len(failed.Key) > 0 {
+				producerMessage.Key = sarama.ByteEncoder(failed.Key)
+			}
+			if k.headers() {
+				for hk, hv := range failed.Headers {
+					producerMessage.Headers = append(producerMessage.Headers, sarama.RecordHeader{Key: []byte(hk), Value: []byte(hv)})
+				}
+			}
+
+			_, _, err := k.SyncProducerCollector.SendMessage(producerMessage)
+			return err
+		}, opts...)
+}
+
+func newKafkaFailedMessage(msg *sarama.ConsumerMessage) *KafkaFailedMessage {
+	failed := &KafkaFailedMessage{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Timestamp: msg.Timestamp,
+	}
+	if len(msg.Headers) > 0 {
+		failed.Headers = make(map[string]string, len(msg.Headers))
+		for _, h := range msg.Headers {
+			failed.Headers[string(h.Key)] = string(h.Value)
+		}
+	}
+	return failed
+}
+
+//message 还原原始消息
+func (f *KafkaFailedMessage) message() *sarama.ConsumerMessage {
+	msg := &sarama.ConsumerMessage{
+		Topic:     f.Topic,
+		Partition: f.Partition,
+		Offset:    f.Offset,
+		Key:       f.Key,
+		Value:     f.Value,
+		Timestamp: f.Timestamp,
+	}
+	for k, v := range f.Headers {
+		msg.Headers = append(msg.Headers, &sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+	}
+	return msg
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,193 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/GreatSir/realclouds_go/utils"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	//OUTBOX_LOCK_KEY 发送进程锁, 保证只有一个 relay 在运行
+	OUTBOX_LOCK_KEY = "kafka_outbox_relay"
+)
+
+var (
+	errOutboxLockLost = errors.New("Outbox relay lock lost.")
+)
+
+//KafkaOutbox 待发送的 Kafka 消息, 与业务数据在同一事务中写入
+type KafkaOutbox struct {
+	ID        uint64     `gorm:"primary_key;column:id;AUTO_INCREMENT" json:"id,omitempty" xml:"id,omitempty"`
+	Topic     string     `gorm:"column:topic;type:varchar(255)" json:"topic,omitempty" xml:"topic,omitempty"`
+	Key       string     `gorm:"column:msg_key;type:varchar(255)" json:"key,omitempty" xml:"key,omitempty"`
+	Value     []byte     `gorm:"column:value;type:longblob" json:"value,omitempty" xml:"value,omitempty"`
+	Attempts  int        `gorm:"column:attempts;type:int(11)" json:"attempts" xml:"attempts"`
+	LastError string     `gorm:"column:last_error;type:text" json:"last_error,omitempty" xml:"last_error,omitempty"`
+	CreatedAt time.Time  `gorm:"column:created_at;type:timestamp" json:"created_at,omitempty" xml:"created_at,omitempty"`
+	SentAt    *time.Time `sql:"index" gorm:"column:sent_at;type:timestamp NULL" json:"sent_at,omitempty" xml:"sent_at,omitempty"`
+}
+
+//TableName *
+func (KafkaOutbox) TableName() string {
+	return "sys_kafka_outbox"
+}
+
+//AddOutboxMessage 在事务 tx 中写入待发送消息, 事务提交后由 OutboxRelay 发送
+func AddOutboxMessage(tx *gorm.DB, topic string, msg KafkaMsg, key ...string) error {
+	value, err := json.Marshal(&msg)
+	if nil != err {
+		return err
+	}
+
+	data := &KafkaOutbox{
+		Topic: strings.TrimSpace(topic),
+		Value: value,
+	}
+	if len(key) > 0 {
+		data.Key = key[0]
+	}
+
+	return tx.Create(data).Error
+}
+
+//OutboxRelay 按写入顺序发送 outbox 消息
+type OutboxRelay struct {
+	Gorm *gorm.DB
+	//Kafka *Kafka 或测试用的 MemKafka
+	Kafka     KafkaClient
+	Redis     *Redis
+	LockKey   string
+	LockTTL   int
+	Interval  time.Duration
+	BatchSize int
+}
+
+//NewOutboxRelay *
+func NewOutboxRelay(db *gorm.DB, kafka KafkaClient, r *Redis) (*OutboxRelay, error) {
+	if err := db.AutoMigrate(&KafkaOutbox{}).Error; nil != err {
+		return nil, err
+	}
+
+	relay := &OutboxRelay{
+		Gorm:      db,
+		Kafka:     kafka,
+		Redis:     r,
+		LockKey:   OUTBOX_LOCK_KEY,
+		LockTTL:   30,
+		Interval:  time.Second,
+		BatchSize: 100,
+	}
+	return relay, nil
+}
+
+//Run 阻塞运行, 获得 Redis 锁后连续发送, 直到某批不足 BatchSize 后等待下一次间隔; ctx 取消后释放锁
+func (o *OutboxRelay) Run(ctx context.Context) error {
+	token := utils.GenerateUUID()
+	locked := false
+
+	defer func() {
+		if locked {
+			if err := o.Redis.Unlock(o.LockKey, token); nil != err {
+				log.Errorf("Outbox relay unlock error: %v", err)
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(o.Interval)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		if locked, err = o.lock(token, locked); nil != err {
+			log.Errorf("Outbox relay lock error: %v", err)
+		}
+
+		//批次之间续期锁, 锁丢失时停止
+		for locked && nil == ctx.Err() {
+			n, err := o.relay(ctx, token)
+			if nil != err {
+				if err == errOutboxLockLost {
+					locked = false
+				}
+				log.Errorf("Outbox relay error: %v", err)
+				break
+			}
+			if n == 0 || n < o.BatchSize {
+				break
+			}
+			if locked, err = o.lock(token, locked); nil != err {
+				log.Errorf("Outbox relay lock error: %v", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+//lock 已持有锁时续期, 否则尝试获取
+func (o *OutboxRelay) lock(token string, locked bool) (bool, error) {
+	if locked {
+		return o.Redis.RenewLock(o.LockKey, token, o.LockTTL)
+	}
+	return o.Redis.TryLock(o.LockKey, token, o.LockTTL)
+}
+
+//relay 按 ID 顺序发送一批消息, 返回发送成功的条数; 发送失败时停止, 保证顺序
+//发送耗时超过 LockTTL 的一半时续期锁, 锁已被其他进程获得时停止; LockTTL 应大于单条消息的最长发送时间
+func (o *OutboxRelay) relay(ctx context.Context, token string) (int, error) {
+	messages := make([]KafkaOutbox, 0)
+	if err := o.Gorm.Where("sent_at IS NULL").Order("id ASC").Limit(o.BatchSize).Find(&messages).Error; nil != err {
+		return 0, err
+	}
+
+	renewAt := time.Now().Add(time.Duration(o.LockTTL) * time.Second / 2)
+	for i, msg := range messages {
+		if nil != ctx.Err() {
+			return i, nil
+		}
+
+		if time.Now().After(renewAt) {
+			ok, err := o.Redis.RenewLock(o.LockKey, token, o.LockTTL)
+			if nil != err {
+				return i, err
+			}
+			if !ok {
+				return i, errOutboxLockLost
+			}
+			renewAt = time.Now().Add(time.Duration(o.LockTTL) * time.Second / 2)
+		}
+
+		//直接发送写入时编码的内容, message-id 固定为 outbox ID, 重发时消费者可据此去重
+		value := KafkaMsg{encoded: msg.Value}
+		opt := KafkaSendOpt{
+			Key:     msg.Key,
+			Headers: map[string]string{KAFKA_HEADER_MESSAGE_ID: fmt.Sprintf("outbox-%d", msg.ID)},
+		}
+
+		if _, _, err := o.Kafka.SyncSend(ctx, msg.Topic, value, opt); nil != err {
+			if uerr := o.Gorm.Model(&msg).UpdateColumns(map[string]interface{}{
+				"attempts":   gorm.Expr("attempts + ?", 1),
+				"last_error": err.Error(),
+			}).Error; nil != uerr {
+				return i, fmt.Errorf("%v, update attempts error: %v", err, uerr)
+			}
+			return i, err
+		}
+
+		if err := o.Gorm.Model(&msg).UpdateColumn("sent_at", time.Now()).Error; nil != err {
+			return i, err
+		}
+	}
+	return len(messages), nil
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+)
+
+func TestOutboxRelay(t *testing.T) {
+	db := openTestDB(t, &KafkaOutbox{})
+	mem := NewMemKafka()
+
+	for _, receiver := range []string{"u1", "u2", "u3"} {
+		if err := AddOutboxMessage(db, "notify", KafkaMsg{Receiver: []string{receiver}}, receiver); nil != err {
+			t.Fatalf("AddOutboxMessage() error = %v", err)
+		}
+	}
+
+	relay := &OutboxRelay{Gorm: db, Kafka: mem, LockTTL: 3600, BatchSize: 2}
+	for _, want := range []int{2, 1, 0} {
+		if n, err := relay.relay(context.Background(), "token"); nil != err || n != want {
+			t.Fatalf("relay() = %d, %v, want %d", n, err, want)
+		}
+	}
+
+	messages, err := mem.DecodeMessages("notify")
+	if nil != err || len(messages) != 3 {
+		t.Fatalf("DecodeMessages() = %d, %v, want 3", len(messages), err)
+	}
+	for i, receiver := range []string{"u1", "u2", "u3"} {
+		if messages[i].Receiver[0] != receiver {
+			t.Errorf("message %d receiver = %v, want %s", i, messages[i].Receiver, receiver)
+		}
+	}
+
+	records := mem.Messages("notify")
+	if string(records[0].Key) != "u1" || records[0].Headers[KAFKA_HEADER_MESSAGE_ID] != "outbox-1" {
+		t.Errorf("record = key %q, message id %q, want u1, outbox-1", records[0].Key, records[0].Headers[KAFKA_HEADER_MESSAGE_ID])
+	}
+
+	pending := 0
+	if err := db.Model(&KafkaOutbox{}).Where("sent_at IS NULL").Count(&pending).Error; nil != err || pending != 0 {
+		t.Errorf("pending = %d, %v, want 0", pending, err)
+	}
+}
@@ -0,0 +1,414 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	//PUSH_CHANNEL Redis pub/sub channel, 各副本通过该 channel 互相转发事件
+	PUSH_CHANNEL = "push:events"
+	//PUSH_SEQ_KEY 事件 ID 序列
+	PUSH_SEQ_KEY = "push:seq"
+	//PUSH_EVENTS_PREFIX 每个接收者最近事件的 zset, score 为事件 ID
+	PUSH_EVENTS_PREFIX = "push:events:"
+	//PUSH_BROADCAST 公共消息的接收者, 推送给所有在线用户
+	PUSH_BROADCAST = "*"
+)
+
+var (
+	//生成事件 ID, 写入历史并发布; ARGV: receiver(JSON 字符串), msg(JSON), history, ttl, channel
+	pushPublishScript = redis.NewScript(2, `
+local id = redis.call("INCR", KEYS[2])
+local event = '{"id":' .. id .. ',"receiver":' .. ARGV[1] .. ',"msg":' .. ARGV[2] .. '}'
+redis.call("ZADD", KEYS[1], id, event)
+redis.call("ZREMRANGEBYRANK", KEYS[1], 0, -(tonumber(ARGV[3]) + 1))
+redis.call("EXPIRE", KEYS[1], ARGV[4])
+redis.call("PUBLISH", ARGV[5], event)
+return id`)
+)
+
+//PushEvent 推送事件, ID 全局递增, 客户端断线重连时通过 Last-Event-ID 续传
+type PushEvent struct {
+	ID       int64    `json:"id" xml:"id"`
+	Receiver string   `json:"receiver" xml:"receiver"`
+	Msg      KafkaMsg `json:"msg" xml:"msg"`
+}
+
+//PushHub 通过 SSE 或 WebSocket 向在线用户推送 KafkaMsg
+//事件经 Redis pub/sub 发送到所有副本, 由持有连接的副本推送
+type PushHub struct {
+	Redis *Redis
+	//SessionKey Session 中当前用户 ID 的 key
+	SessionKey string
+	Channel    string
+	//Heartbeat 心跳间隔
+	Heartbeat time.Duration
+	//History 每个接收者保留的最近事件数, 用于断线续传
+	History int
+	//HistoryTTL 历史事件保留秒数
+	HistoryTTL int
+	//Buffer 每个连接的待发送事件数, 超出时断开连接, 由客户端重连续传
+	Buffer int
+	//AllowedOrigins WebSocket 允许的 Origin (如 https://example.com), 与请求 Host 相同的 Origin 始终允许
+	AllowedOrigins []string
+
+	mutex   sync.RWMutex
+	clients map[string]map[*pushClient]bool
+}
+
+type pushClient struct {
+	receiver string
+	events   chan *PushEvent
+	done     chan struct{}
+	once     sync.Once
+}
+
+func (p *pushClient) close() {
+	p.once.Do(func() { close(p.done) })
+}
+
+//NewPushHub *
+func NewPushHub(r *Redis, sessionKey string) *PushHub {
+	return &PushHub{
+		Redis:      r,
+		SessionKey: sessionKey,
+		Channel:    PUSH_CHANNEL,
+		Heartbeat:  30 * time.Second,
+		History:    100,
+		HistoryTTL: 86400,
+		Buffer:     64,
+		clients:    make(map[string]map[*pushClient]bool),
+	}
+}
+
+//Run 订阅 Redis channel 并分发到本副本的连接, 阻塞直到 ctx 取消
+//Redis 出错时记录日志并按 1s 起指数退避(最长 30s)重新订阅; 断开期间的事件不会推送到在线连接, 客户端重连后可续传
+func (h *PushHub) Run(ctx context.Context) error {
+	backoff := time.Second
+	for {
+		err := h.Redis.ListenPubSubChannels(ctx,
+			func() error {
+				backoff = time.Second
+				return nil
+			},
+			func(channel string, data []byte) error {
+				event := &PushEvent{}
+				if err := json.Unmarshal(data, event); nil != err {
+					log.Errorf("Push hub decode error: %v", err)
+					return nil
+				}
+				h.dispatch(event)
+				return nil
+			}, nil, []string{h.Channel}, nil)
+		if nil != ctx.Err() {
+			return nil
+		}
+		if nil == err {
+			err = fmt.Errorf("%s", "Push hub subscription closed.")
+		}
+		log.Errorf("Push hub subscribe error: %v, retry in %v", err, backoff)
+
+		if nil != sleepContext(ctx, backoff) {
+			return nil
+		}
+		if backoff *= 2; backoff > 30*time.Second {
+			backoff = 30 * time.Second
+		}
+	}
+}
+
+//Consume 订阅通知 topic 并推送, 阻塞直到 ctx 取消
+func (h *PushHub) Consume(ctx context.Context, kafka KafkaClient, topics []string, group string, opts ...KafkaConsumerOpt) error {
+	return kafka.SubscribeMessage(ctx, topics, group, h.HandleMessage, opts...)
+}
+
+//HandleMessage 解码 KafkaMsg 并推送
+func (h *PushHub) HandleMessage(ctx context.Context, msg *KafkaMessage) error {
+	data, err := msg.Decode()
+	if nil != err {
+		return err
+	}
+	return h.Publish(&data)
+}
+
+//Publish 为每个接收者生成事件并发布到所有副本; 公共消息未指定接收者时推送给所有在线用户
+func (h *PushHub) Publish(msg *KafkaMsg) error {
+	receivers := msg.Receiver
+	if len(receivers) == 0 && msg.Message.Type == NotifyPublic {
+		receivers = []string{PUSH_BROADCAST}
+	}
+
+	value, err := json.Marshal(msg)
+	if nil != err {
+		return err
+	}
+
+	conn := h.Redis.RedisPool.Get()
+	defer conn.Close()
+	if err = conn.Err(); nil != err {
+		return err
+	}
+
+	for _, receiver := range receivers {
+		receiver = strings.TrimSpace(receiver)
+		if len(receiver) == 0 {
+			continue
+		}
+		name, _ := json.Marshal(receiver)
+		if _, err = pushPublishScript.Do(conn, PUSH_EVENTS_PREFIX+receiver, PUSH_SEQ_KEY,
+			name, value, h.History, h.HistoryTTL, h.Channel); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+//Online 本副本中 receiver 的连接数
+func (h *PushHub) Online(receiver string) int {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return len(h.clients[receiver])
+}
+
+//SSE Server-Sent Events 接口, 通过 Last-Event-ID 请求头或 last_event_id 参数续传
+func (h *PushHub) SSE(ec echo.Context) error {
+	c := NewCtx(ec)
+	receiver, err := c.SessionUser(h.SessionKey)
+	if nil != err {
+		return err
+	}
+
+	lastID := lastEventID(c.Request().Header.Get("Last-Event-ID"), c.QueryParam("last_event_id"))
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	fmt.Fprintf(res, "retry: %d\n\n", 3000)
+	res.Flush()
+
+	return h.serve(c.Request().Context(), receiver, lastID,
+		func(event *PushEvent) error {
+			data, err := json.Marshal(&event.Msg)
+			if nil != err {
+				return err
+			}
+			if _, err := fmt.Fprintf(res, "id: %d\nevent: message\ndata: %s\n\n", event.ID, data); nil != err {
+				return err
+			}
+			res.Flush()
+			return nil
+		},
+		func() error {
+			if _, err := fmt.Fprint(res, ": ping\n\n"); nil != err {
+				return err
+			}
+			res.Flush()
+			return nil
+		})
+}
+
+//WebSocket WebSocket 接口, 通过 last_event_id 参数续传, 只接受同源或 AllowedOrigins 中的 Origin
+//服务端发送 {"id":1,"type":"message","data":KafkaMsg} 及 {"type":"ping"}
+func (h *PushHub) WebSocket(ec echo.Context) error {
+	c := NewCtx(ec)
+	receiver, err := c.SessionUser(h.SessionKey)
+	if nil != err {
+		return err
+	}
+
+	lastID := lastEventID(c.QueryParam("last_event_id"))
+
+	websocket.Server{Handshake: h.checkOrigin, Handler: func(ws *websocket.Conn) {
+		defer ws.Close()
+
+		ctx, cancel := context.WithCancel(c.Request().Context())
+		defer cancel()
+
+		//客户端关闭或读取出错时结束
+		go func() {
+			defer cancel()
+			var discard string
+			for {
+				if err := websocket.Message.Receive(ws, &discard); nil != err {
+					return
+				}
+			}
+		}()
+
+		err := h.serve(ctx, receiver, lastID,
+			func(event *PushEvent) error {
+				return websocket.JSON.Send(ws, map[string]interface{}{
+					"id":   event.ID,
+					"type": "message",
+					"data": &event.Msg,
+				})
+			},
+			func() error {
+				return websocket.JSON.Send(ws, map[string]string{"type": "ping"})
+			})
+		if nil != err && nil == ctx.Err() {
+			log.Errorf("Push hub websocket error: %v", err)
+		}
+	}}.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+//checkOrigin 只允许同源或 AllowedOrigins 中的页面建立连接, 防止跨站 WebSocket 劫持
+func (h *PushHub) checkOrigin(config *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(config, r)
+	if nil != err {
+		return err
+	}
+	if nil == origin {
+		return fmt.Errorf("%s", "Push hub websocket: missing origin.")
+	}
+	config.Origin = origin
+
+	if strings.EqualFold(origin.Host, r.Host) {
+		return nil
+	}
+	for _, allowed := range h.AllowedOrigins {
+		if strings.EqualFold(strings.TrimRight(allowed, "/"), origin.Scheme+"://"+origin.Host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("Push hub websocket: origin not allowed: %s", origin)
+}
+
+//serve 注册连接, 补发 lastID 之后的历史事件, 然后推送实时事件及心跳, 直到 ctx 取消或发送出错
+func (h *PushHub) serve(ctx context.Context, receiver string, lastID int64,
+	send func(event *PushEvent) error, ping func() error) error {
+
+	client := h.register(receiver)
+	defer h.unregister(client)
+
+	if lastID > 0 {
+		events, err := h.history(receiver, lastID)
+		if nil != err {
+			return err
+		}
+		for _, event := range events {
+			if err := send(event); nil != err {
+				return err
+			}
+			lastID = event.ID
+		}
+	}
+
+	ticker := time.NewTicker(h.Heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-client.done:
+			return nil
+		case <-ticker.C:
+			if err := ping(); nil != err {
+				return err
+			}
+		case event := <-client.events:
+			if event.ID <= lastID {
+				continue
+			}
+			if err := send(event); nil != err {
+				return err
+			}
+			lastID = event.ID
+		}
+	}
+}
+
+//history 接收者及公共消息中 ID 大于 lastID 的事件, 按 ID 排序
+func (h *PushHub) history(receiver string, lastID int64) ([]*PushEvent, error) {
+	conn := h.Redis.RedisPool.Get()
+	defer conn.Close()
+
+	events := make([]*PushEvent, 0)
+	for _, key := range []string{receiver, PUSH_BROADCAST} {
+		values, err := redis.ByteSlices(conn.Do("ZRANGEBYSCORE", PUSH_EVENTS_PREFIX+key, "("+strconv.FormatInt(lastID, 10), "+inf"))
+		if nil != err {
+			return nil, err
+		}
+		for _, value := range values {
+			event := &PushEvent{}
+			if err := json.Unmarshal(value, event); nil != err {
+				return nil, err
+			}
+			events = append(events, event)
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	return events, nil
+}
+
+func (h *PushHub) register(receiver string) *pushClient {
+	client := &pushClient{
+		receiver: receiver,
+		events:   make(chan *PushEvent, h.Buffer),
+		done:     make(chan struct{}),
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if nil == h.clients[receiver] {
+		h.clients[receiver] = make(map[*pushClient]bool)
+	}
+	h.clients[receiver][client] = true
+	return client
+}
+
+func (h *PushHub) unregister(client *pushClient) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	delete(h.clients[client.receiver], client)
+	if len(h.clients[client.receiver]) == 0 {
+		delete(h.clients, client.receiver)
+	}
+}
+
+//dispatch 发送到本副本的连接, 缓冲区已满的连接被断开
+func (h *PushHub) dispatch(event *PushEvent) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for receiver, clients := range h.clients {
+		if event.Receiver != PUSH_BROADCAST && event.Receiver != receiver {
+			continue
+		}
+		for client := range clients {
+			select {
+			case client.events <- event:
+			default:
+				client.close()
+			}
+		}
+	}
+}
+
+func lastEventID(values ...string) int64 {
+	for _, val := range values {
+		if id, err := strconv.ParseInt(strings.TrimSpace(val), 10, 64); nil == err && id > 0 {
+			return id
+		}
+	}
+	return 0
+}
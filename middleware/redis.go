@@ -74,11 +74,9 @@ func (r *Redis) SrvInfo(section string) (data string, err error) {
 	return
 }
 
-//MwRedis Redis middleware
+//MwRedis Redis middleware, 连接池并发安全, 不对请求加锁, SSE/WebSocket 等长连接可挂在其后
 func (r *Redis) MwRedis(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		r.Mutex.Lock()
-		defer r.Mutex.Unlock()
 		c.Set("redis", r)
 		return next(c)
 	}
@@ -114,7 +112,57 @@ func (r *Redis) Publish(key string, msg interface{}) (err error) {
 	return
 }
 
-//ListenPubSubChannels *
+var (
+	renewLockScript = redis.NewScript(1, `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("EXPIRE", KEYS[1], ARGV[2]) else return 0 end`)
+	unlockScript    = redis.NewScript(1, `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) else return 0 end`)
+)
+
+//TryLock 分布式锁, SET key token NX EX exp, 获取成功返回 true
+func (r *Redis) TryLock(key, token string, exp int) (ok bool, err error) {
+	key = strings.TrimSpace(key)
+	conn := r.RedisPool.Get()
+	defer conn.Close()
+	if err = conn.Err(); err != nil {
+		return
+	}
+	_, err = redis.String(conn.Do("SET", key, token, "NX", "EX", exp))
+	if redis.ErrNil == err {
+		return false, nil
+	}
+	if nil != err {
+		return
+	}
+	return true, nil
+}
+
+//RenewLock 锁续期, token 不一致(锁已丢失)时返回 false
+func (r *Redis) RenewLock(key, token string, exp int) (ok bool, err error) {
+	key = strings.TrimSpace(key)
+	conn := r.RedisPool.Get()
+	defer conn.Close()
+	if err = conn.Err(); err != nil {
+		return
+	}
+	n, err := redis.Int(renewLockScript.Do(conn, key, token, exp))
+	if nil != err {
+		return
+	}
+	return n == 1, nil
+}
+
+//Unlock 释放锁, 只删除 token 一致的锁
+func (r *Redis) Unlock(key, token string) (err error) {
+	key = strings.TrimSpace(key)
+	conn := r.RedisPool.Get()
+	defer conn.Close()
+	if err = conn.Err(); err != nil {
+		return
+	}
+	_, err = unlockScript.Do(conn, key, token)
+	return
+}
+
+//ListenPubSubChannels 订阅并阻塞, 直到回调出错或 ctx 取消; pChannels 为 PSUBSCRIBE 模式
 func (r *Redis) ListenPubSubChannels(
 	ctx context.Context,
 	onStart func() error,
@@ -165,7 +213,7 @@ func listenPubSubChannels(
 	}
 
 	if len(pChannels) > 0 {
-		if err := psc.PSubscribe(redis.Args{}.AddFlat(channels)...); err != nil {
+		if err := psc.PSubscribe(redis.Args{}.AddFlat(pChannels)...); err != nil {
 			return err
 		}
 	}
@@ -179,25 +227,27 @@ func listenPubSubChannels(
 				done <- n
 				return
 			case redis.Message:
-				if nil != onMessage {
+				if len(n.Pattern) > 0 {
+					if nil != onPMessage {
+						if err := onPMessage(n.Pattern, n.Channel, n.Data); err != nil {
+							done <- err
+							return
+						}
+					}
+				} else if nil != onMessage {
 					if err := onMessage(n.Channel, n.Data); err != nil {
 						done <- err
 						return
 					}
 				}
-			//case redis.PMessage:
-			//	if nil != onPMessage {
-			//		if err := onPMessage(n.Pattern, n.Channel, n.Data); err != nil {
-			//			done <- err
-			//			return
-			//		}
-			//	}
 			case redis.Subscription:
 				switch n.Count {
-				case len(channels):
-					if err := onStart(); err != nil {
-						done <- err
-						return
+				case len(channels) + len(pChannels):
+					if nil != onStart {
+						if err := onStart(); err != nil {
+							done <- err
+							return
+						}
 					}
 				case 0:
 					done <- nil
@@ -207,7 +257,18 @@ func listenPubSubChannels(
 		}
 	}()
 
-	return <-done
+	//ctx 取消后退订, 等待接收 goroutine 退出
+	select {
+	case <-ctx.Done():
+		psc.Unsubscribe()
+		psc.PUnsubscribe()
+		return <-done
+	case err = <-done:
+	}
+
+	psc.Unsubscribe()
+	psc.PUnsubscribe()
+	return
 }
 
 //FlushDB **
@@ -1,26 +1,43 @@
 package middleware
 
 import (
+	"bytes"
+	"fmt"
 	"html/template"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
-	"runtime"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/GreatSir/realclouds_go/utils"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	//页面第一行声明布局: {%/* layout "layouts/main" */%}
+	layoutRegexp = regexp.MustCompile(`^\s*\{%/\*\s*layout\s+"([^"]+)"\s*\*/%\}`)
 )
 
 //RenderOpt 自定义 Template 参数
 type RenderOpt struct {
 	Directory string
 	Suffix    string
-	PreTpl    []string
-	DevMode   bool
+	//PreTpl 加入所有页面的公共模板, 相对 Directory 且不含后缀
+	PreTpl []string
+	//SharedDirs 该目录下的模板作为布局及公共片段加入所有页面, 默认 layouts, partials
+	SharedDirs []string
+	DevMode    bool
+	//DrityWord 模板函数 drityword 使用的敏感词库
+	DrityWord *DrityWord
+	//Funcs 自定义模板函数, 与内置函数同名时覆盖内置函数
+	Funcs template.FuncMap
 }
 
 type tmplPath struct {
@@ -29,22 +46,32 @@ type tmplPath struct {
 	suffix string
 }
 
+//tmplPage 页面模板集, 由公共模板 Clone 后加入页面, 页面之间的 block 互不影响
+type tmplPage struct {
+	tpl    *template.Template
+	layout string
+}
+
 //Template *
 type Template struct {
-	templates *template.Template
-	tmplPaths map[string]tmplPath
-	suffix    string
-	directory string
-	preTpl    []string
-	devmode   bool
+	mutex      sync.RWMutex
+	pages      map[string]*tmplPage
+	err        error
+	watcher    *fsnotify.Watcher
+	suffix     string
+	directory  string
+	preTpl     []string
+	sharedDirs []string
+	devmode    bool
+	funcs      template.FuncMap
 }
 
 //MwRender Echo 自定义 Render
+//页面可在第一行声明布局 {%/* layout "layouts/main" */%}, 渲染时执行布局, 页面中 define 的模板覆盖布局中的同名 block
+//生产模式下模板加载失败时 panic; 开发模式下监听模板目录, 文件变化后自动重新加载
 func MwRender(opts ...RenderOpt) *Template {
 	t := &Template{}
 
-	t.tmplPaths = make(map[string]tmplPath)
-
 	var opt RenderOpt
 
 	if len(opts) > 0 {
@@ -58,12 +85,95 @@ func MwRender(opts ...RenderOpt) *Template {
 		opt.Suffix = ".html"
 	}
 
-	t.directory = opt.Directory
+	if nil == opt.SharedDirs {
+		opt.SharedDirs = []string{"layouts", "partials"}
+	}
+
+	t.directory = strings.TrimRight(opt.Directory, utils.PathSeparator)
 	t.suffix = opt.Suffix
 	t.preTpl = opt.PreTpl
+	t.sharedDirs = opt.SharedDirs
 	t.devmode = opt.DevMode
 
-	templatePathWalk := func(p string, f os.FileInfo, err error) error {
+	t.funcs = TemplateFuncs(opt.DrityWord)
+	for name, fn := range opt.Funcs {
+		t.funcs[name] = fn
+	}
+
+	if err := t.reload(); nil != err {
+		//生产模式下启动时加载失败直接退出, 开发模式下记录错误, 渲染时输出调试页面
+		if !t.devmode {
+			log.Panicf("Load templates error: %v", err)
+		}
+		log.Errorf("Load templates error: %v", err)
+	}
+
+	if t.devmode {
+		if err := t.watch(); nil != err {
+			log.Errorf("Watch templates error: %v", err)
+		}
+	}
+
+	return t
+}
+
+// Render renders a template document
+//开发模式下解析或执行错误输出为调试页面, 显示出错的文件及行号
+func (t *Template) Render(w io.Writer, name string, data interface{}, c echo.Context) (err error) {
+	name = filepath.ToSlash(name)
+
+	if err = t.execute(w, name, data); nil != err && t.devmode {
+		return t.renderError(w, err, c)
+	}
+	return err
+}
+
+func (t *Template) execute(w io.Writer, name string, data interface{}) (err error) {
+	t.mutex.RLock()
+	pages, err := t.pages, t.err
+	t.mutex.RUnlock()
+	if nil != err {
+		return err
+	}
+
+	page, ok := pages[name]
+	if !ok {
+		return fmt.Errorf("Template not found: %s", name)
+	}
+
+	//执行出错时不输出部分内容
+	var buf bytes.Buffer
+	if len(page.layout) != 0 {
+		err = page.tpl.ExecuteTemplate(&buf, page.layout, data)
+	} else {
+		err = page.tpl.ExecuteTemplate(&buf, name, data)
+	}
+	if nil != err {
+		return err
+	}
+
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+//reload 重新加载模板, 出错时保留错误, 渲染时返回
+func (t *Template) reload() error {
+	pages, err := t.load()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.err = err
+	if nil == err {
+		t.pages = pages
+	}
+	return err
+}
+
+//paths 模板名称(相对路径, 不含后缀, 以 / 分隔)及文件路径
+func (t *Template) paths() (map[string]tmplPath, error) {
+	tmplPaths := make(map[string]tmplPath)
+
+	err := filepath.Walk(t.directory, func(p string, f os.FileInfo, err error) error {
 		if f == nil {
 			return err
 		} else if f.IsDir() {
@@ -72,76 +182,100 @@ func MwRender(opts ...RenderOpt) *Template {
 			return nil
 		}
 
-		if f.Size() > 0 {
-			if strings.HasSuffix(p, opt.Suffix) {
-				t.tmplPaths[strings.TrimSuffix(p, opt.Suffix)] = tmplPath{path: p, name: f.Name(), suffix: opt.Suffix}
+		if f.Size() > 0 && strings.HasSuffix(strings.ToLower(p), strings.ToLower(t.suffix)) {
+			rel, rerr := filepath.Rel(t.directory, p)
+			if nil != rerr {
+				return rerr
 			}
+			name := filepath.ToSlash(rel[:len(rel)-len(t.suffix)])
+			tmplPaths[name] = tmplPath{path: p, name: f.Name(), suffix: t.suffix}
 		}
 		return err
-	}
-
-	err := filepath.Walk(strings.TrimRight(opt.Directory, utils.PathSeparator), func(p string, f os.FileInfo, err error) error {
-		return templatePathWalk(p, f, err)
 	})
+	return tmplPaths, err
+}
 
-	for k, v := range t.tmplPaths {
-		if strings.EqualFold(strings.ToLower(v.suffix), strings.ToLower(opt.Suffix)) {
-			tk := k[len(opt.Directory)+1 : len(k)]
-			htmlStr, _ := ioutil.ReadFile(v.path)
-			htmlTxt := string(htmlStr)
-			if len(htmlTxt) != 0 {
-				var tpl *template.Template
-				if t.templates == nil {
-					t.templates = template.New(tk)
-				}
-				if tk == t.templates.Name() {
-					tpl = t.templates
-				} else {
-					tpl = t.templates.New(tk)
-				}
-				tpl = tpl.Delims("{%", "%}")
-				_, err = tpl.Parse(htmlTxt)
-				t.templates = template.Must(t.templates, err)
-			}
+func (t *Template) shared(name string) bool {
+	for _, tpl := range t.preTpl {
+		if filepath.ToSlash(tpl) == name {
+			return true
 		}
 	}
-	return t
+	for _, dir := range t.sharedDirs {
+		if strings.HasPrefix(name, strings.Trim(filepath.ToSlash(dir), "/")+"/") {
+			return true
+		}
+	}
+	return false
 }
 
-// Render renders a template document
-func (t *Template) Render(w io.Writer, name string, data interface{}, c echo.Context) (err error) {
+//load 解析公共模板及各页面主体, 每个页面从公共模板 Clone 后解析页面
+//页面之间可以通过 {% template "other/page" . %} 引用, 被引用时只执行页面主体, 不执行布局及页面中 define 的模板
+func (t *Template) load() (map[string]*tmplPage, error) {
+	tmplPaths, err := t.paths()
+	if nil != err {
+		return nil, err
+	}
 
-	if runtime.GOOS == "windows" {
-		name = strings.Replace(name, "/", utils.PathSeparator, -1)
+	names := make([]string, 0, len(tmplPaths))
+	for name := range tmplPaths {
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
-	if t.devmode {
-		b, err := ioutil.ReadFile(t.templateFullPath(name))
+	contents := make(map[string]string, len(names))
+	for _, name := range names {
+		b, err := ioutil.ReadFile(tmplPaths[name].path)
 		if nil != err {
-			log.Panicf("Read html file error: %v", err)
-			return err
+			return nil, err
 		}
-		htmlStr := string(b)
-
-		tmpls := make([]string, 0, 0)
+		contents[name] = string(b)
+	}
 
-		for _, tmpl := range t.preTpl {
-			tmpls = append(tmpls, t.templateFullPath(tmpl))
+	base := template.New("").Delims("{%", "%}").Funcs(t.funcs)
+	for _, name := range names {
+		if !t.shared(name) {
+			continue
 		}
-
-		tpl := template.New("").Delims("{%", "%}")
-		if 0 != len(tmpls) {
-			tpl, err = tpl.ParseFiles(tmpls...)
+		if _, err := base.New(name).Parse(contents[name]); nil != err {
+			return nil, err
 		}
+	}
 
-		tpl = tpl.New(name).Delims("{%", "%}")
-		tpl, err = tpl.Parse(htmlStr)
-		return tpl.Execute(w, data)
+	//页面单独解析后只将主体加入公共模板, 避免页面之间的 define 互相覆盖
+	for _, name := range names {
+		if t.shared(name) {
+			continue
+		}
+		tpl, err := template.New(name).Delims("{%", "%}").Funcs(t.funcs).Parse(contents[name])
+		if nil != err {
+			return nil, err
+		}
+		if _, err := base.AddParseTree(name, tpl.Tree); nil != err {
+			return nil, err
+		}
 	}
 
-	return t.templates.ExecuteTemplate(w, name, data)
-}
+	pages := make(map[string]*tmplPage, len(names))
+	for _, name := range names {
+		tpl, err := base.Clone()
+		if nil != err {
+			return nil, err
+		}
 
-func (t *Template) templateFullPath(name string) string {
-	return t.directory + utils.PathSeparator + name + t.suffix
+		page := &tmplPage{tpl: tpl}
+		if !t.shared(name) {
+			if _, err := tpl.New(name).Parse(contents[name]); nil != err {
+				return nil, err
+			}
+			if m := layoutRegexp.FindStringSubmatch(contents[name]); nil != m {
+				page.layout = m[1]
+				if nil == tpl.Lookup(page.layout) {
+					return nil, fmt.Errorf("template: %s:1: layout not found: %s", name, page.layout)
+				}
+			}
+		}
+		pages[name] = page
+	}
+	return pages, nil
 }
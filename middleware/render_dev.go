@@ -0,0 +1,184 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	//template: NAME:LINE: msg, template: NAME:LINE:COL: executing ..., html/template:NAME:LINE: msg
+	templateErrorRegexp = regexp.MustCompile(`(?:html/)?template: ?([^:\s]+):(\d+)(?::\d+)?:\s*(.*)`)
+)
+
+//TemplateError 模板解析或执行错误, 开发模式下渲染为调试页面
+type TemplateError struct {
+	Name    string
+	File    string
+	Line    int
+	Message string
+	Err     error
+}
+
+//Error *
+func (e *TemplateError) Error() string {
+	return e.Err.Error()
+}
+
+//newTemplateError 从模板错误中解析模板名称及行号
+func (t *Template) newTemplateError(err error) *TemplateError {
+	te := &TemplateError{Message: err.Error(), Err: err}
+
+	m := templateErrorRegexp.FindStringSubmatch(err.Error())
+	if nil == m {
+		return te
+	}
+
+	te.Name = m[1]
+	te.Line, _ = strconv.Atoi(m[2])
+	te.Message = m[3]
+	if file := t.templateFullPath(te.Name); fileExists(file) {
+		te.File = file
+	}
+	return te
+}
+
+//html 调试页面, 显示错误及出错行前后的源码
+func (e *TemplateError) html() []byte {
+	var buf bytes.Buffer
+	esc := template.HTMLEscapeString
+
+	buf.WriteString(`<!DOCTYPE html><html><head><meta charset="utf-8"><title>Template Error</title><style>`)
+	buf.WriteString(`body{font-family:sans-serif;margin:2em;color:#333}h1{color:#c00;font-size:1.4em}`)
+	buf.WriteString(`pre{background:#f6f6f6;padding:1em;overflow:auto}.hl{background:#fdd;display:block}`)
+	buf.WriteString(`</style></head><body><h1>Template Error</h1>`)
+
+	if len(e.File) != 0 {
+		fmt.Fprintf(&buf, "<p><b>%s</b> line %d</p>", esc(e.File), e.Line)
+	} else if len(e.Name) != 0 {
+		fmt.Fprintf(&buf, "<p><b>%s</b> line %d</p>", esc(e.Name), e.Line)
+	}
+	fmt.Fprintf(&buf, "<pre>%s</pre>", esc(e.Message))
+
+	if b, err := ioutil.ReadFile(e.File); nil == err && e.Line > 0 {
+		lines := strings.Split(string(b), "\n")
+		start, end := e.Line-6, e.Line+5
+		if start < 0 {
+			start = 0
+		}
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		buf.WriteString("<pre>")
+		for i := start; i < end; i++ {
+			line := fmt.Sprintf("%4d  %s", i+1, esc(lines[i]))
+			if i+1 == e.Line {
+				fmt.Fprintf(&buf, `<span class="hl">%s</span>`, line)
+			} else {
+				buf.WriteString(line + "\n")
+			}
+		}
+		buf.WriteString("</pre>")
+	}
+
+	buf.WriteString("</body></html>")
+	return buf.Bytes()
+}
+
+//renderError 开发模式下输出调试页面, 返回原错误
+func (t *Template) renderError(w io.Writer, err error, c echo.Context) error {
+	te := t.newTemplateError(err)
+	if nil != c {
+		if herr := c.HTMLBlob(http.StatusInternalServerError, te.html()); nil != herr {
+			return herr
+		}
+		return te
+	}
+	w.Write(te.html())
+	return te
+}
+
+//watch 监听模板目录, 文件变化后重新加载
+func (t *Template) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if nil != err {
+		return err
+	}
+
+	err = filepath.Walk(t.directory, func(p string, f os.FileInfo, err error) error {
+		if nil != err {
+			return err
+		}
+		if f.IsDir() {
+			return watcher.Add(p)
+		}
+		return nil
+	})
+	if nil != err {
+		watcher.Close()
+		return err
+	}
+
+	t.watcher = watcher
+
+	go func() {
+		//合并短时间内的多次变化
+		var reload <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&fsnotify.Create == fsnotify.Create {
+					if f, err := os.Stat(event.Name); nil == err && f.IsDir() {
+						watcher.Add(event.Name)
+					}
+				}
+				reload = time.After(100 * time.Millisecond)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Errorf("Template watcher error: %v", err)
+			case <-reload:
+				reload = nil
+				if err := t.reload(); nil != err {
+					log.Errorf("Load templates error: %v", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+//Close 停止监听模板目录
+func (t *Template) Close() error {
+	if nil == t.watcher {
+		return nil
+	}
+	return t.watcher.Close()
+}
+
+func (t *Template) templateFullPath(name string) string {
+	return t.directory + string(filepath.Separator) + filepath.FromSlash(name) + t.suffix
+}
+
+func fileExists(path string) bool {
+	f, err := os.Stat(path)
+	return nil == err && !f.IsDir()
+}
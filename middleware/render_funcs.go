@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/GreatSir/realclouds_go/utils"
+)
+
+const (
+	//DEFAULT_TEMPLATE_DATE_FORMAT 模板 date 函数默认格式
+	DEFAULT_TEMPLATE_DATE_FORMAT = "2006-01-02 15:04:05"
+)
+
+//TemplateFuncs 内置模板函数, 使用 {% .CreatedAt | date "2006-01-02" %} 等方式调用
+//
+//	date      时间格式化, 默认 DEFAULT_TEMPLATE_DATE_FORMAT, 零值输出空字符串
+//	filesize  字节数转为 KB/MB/GB 等
+//	pinyin    汉字加拼音 ruby 注音
+//	drityword 敏感词替换为 *, 未配置 DrityWord 时原样输出
+//	truncate  按字符数截断, 可指定省略后缀, 默认 "..."
+//	json      输出 JSON, 可直接用于 <script> 中
+//	url       拼接路径及参数: url "/search" "q" .Keyword "page" 2
+//	randurl   添加随机参数防止缓存
+//	safe      不转义 HTML
+//	safeurl   不转义 URL
+//	default   值为空时使用默认值: {% .Name | default "匿名" %}
+func TemplateFuncs(drityWord *DrityWord) template.FuncMap {
+	return template.FuncMap{
+		"date":     templateDate,
+		"filesize": templateFileSize,
+		"pinyin": func(s string) template.HTML {
+			return template.HTML(utils.StringUtils(s).PinYinToHTML())
+		},
+		"drityword": func(s string) string {
+			if nil == drityWord || nil == drityWord.DrityWordMap {
+				return s
+			}
+			return drityWord.Filter(s)
+		},
+		"truncate": templateTruncate,
+		"json":     templateJSON,
+		"url":      templateURL,
+		"randurl": func(path string) string {
+			return utils.StringUtils(path).RandURL()
+		},
+		"safe": func(s string) template.HTML {
+			return template.HTML(s)
+		},
+		"safeurl": func(s string) template.URL {
+			return template.URL(s)
+		},
+		"default": templateDefault,
+	}
+}
+
+func templateDate(args ...interface{}) (string, error) {
+	format := DEFAULT_TEMPLATE_DATE_FORMAT
+	if len(args) == 0 || len(args) > 2 {
+		return "", fmt.Errorf("%s", "date: wrong number of args.")
+	}
+	if len(args) == 2 {
+		f, ok := args[0].(string)
+		if !ok {
+			return "", fmt.Errorf("date: invalid format %v.", args[0])
+		}
+		format = f
+	}
+
+	switch t := args[len(args)-1].(type) {
+	case time.Time:
+		if t.IsZero() {
+			return "", nil
+		}
+		return utils.FormatDate(t, format), nil
+	case *time.Time:
+		if nil == t || t.IsZero() {
+			return "", nil
+		}
+		return utils.FormatDate(*t, format), nil
+	case nil:
+		return "", nil
+	default:
+		return "", fmt.Errorf("date: invalid time %v.", t)
+	}
+}
+
+func templateFileSize(size interface{}) (string, error) {
+	v := reflect.ValueOf(size)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return utils.GetFileSizeToUnit(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return utils.GetFileSizeToUnit(int64(v.Uint())), nil
+	case reflect.String:
+		n, err := strconv.ParseInt(v.String(), 10, 64)
+		if nil != err {
+			return "", err
+		}
+		return utils.GetFileSizeToUnit(n), nil
+	}
+	return "", fmt.Errorf("filesize: invalid size %v.", size)
+}
+
+//templateTruncate truncate n [suffix] s
+func templateTruncate(n int, args ...string) (string, error) {
+	if len(args) == 0 || len(args) > 2 {
+		return "", fmt.Errorf("%s", "truncate: wrong number of args.")
+	}
+
+	suffix := "..."
+	if len(args) == 2 {
+		suffix = args[0]
+	}
+
+	s := args[len(args)-1]
+	if utf8.RuneCountInString(s) <= n {
+		return s, nil
+	}
+	return string([]rune(s)[:n]) + suffix, nil
+}
+
+func templateJSON(v interface{}) (template.JS, error) {
+	b, err := json.Marshal(v)
+	return template.JS(b), err
+}
+
+func templateURL(path string, pairs ...interface{}) (string, error) {
+	if len(pairs)%2 != 0 {
+		return "", fmt.Errorf("%s", "url: params must be key value pairs.")
+	}
+	if len(pairs) == 0 {
+		return path, nil
+	}
+
+	values := url.Values{}
+	for i := 0; i < len(pairs); i += 2 {
+		values.Add(utils.ToStr(pairs[i]), utils.ToStr(pairs[i+1]))
+	}
+	if strings.Contains(path, "?") {
+		return path + "&" + values.Encode(), nil
+	}
+	return path + "?" + values.Encode(), nil
+}
+
+func templateDefault(def interface{}, val ...interface{}) interface{} {
+	if len(val) == 0 || nil == val[0] {
+		return def
+	}
+	v := reflect.ValueOf(val[0])
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return def
+		}
+	case reflect.Slice, reflect.Map, reflect.String, reflect.Array:
+		if v.Len() == 0 {
+			return def
+		}
+	default:
+		if v.IsZero() {
+			return def
+		}
+	}
+	return val[0]
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRender(t *testing.T) {
+	tpl := MwRender(RenderOpt{Directory: "testdata/templates"})
+	users := []map[string]string{{"Name": "<a>"}, {"Name": "b"}}
+
+	tests := []struct {
+		name string
+		page string
+		data interface{}
+		want string
+	}{
+		//users/card 中 define 的 content 不影响其他页面
+		{"layout defaults", "index", nil,
+			"<title>Home</title>\n<main></main>\n<footer>footer</footer>\n\n"},
+		//users/list 覆盖 content, 引用 users/card 时只执行页面主体
+		{"block override and include", "users/list", users,
+			"<title>Default</title>\n<main><ul><li>&lt;a&gt;</li>\n<li>b</li>\n</ul></main>\n<footer>footer</footer>\n\n"},
+		{"page without layout", "users/card", users[1],
+			"<li>b</li>\n"},
+		{"shared template", "partials/footer", nil,
+			"<footer>footer</footer>\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tpl.Render(&buf, tt.page, tt.data, nil); nil != err {
+				t.Fatalf("Render() error = %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("Render() = %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Render(&buf, "missing", nil, nil); nil == err {
+		t.Error("Render() missing page error = nil, want error")
+	}
+}
+
+func TestRenderDevReload(t *testing.T) {
+	dir := t.TempDir()
+	page := filepath.Join(dir, "index.html")
+	if err := ioutil.WriteFile(page, []byte("v1"), 0666); nil != err {
+		t.Fatal(err)
+	}
+
+	tpl := MwRender(RenderOpt{Directory: dir, DevMode: true})
+	defer tpl.Close()
+
+	render := func() string {
+		var buf bytes.Buffer
+		if err := tpl.Render(&buf, "index", nil, nil); nil != err {
+			return err.Error()
+		}
+		return buf.String()
+	}
+	if got := render(); got != "v1" {
+		t.Fatalf("Render() = %q, want v1", got)
+	}
+
+	if err := ioutil.WriteFile(page, []byte("v2"), 0666); nil != err {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for render() != "v2" {
+		if time.Now().After(deadline) {
+			t.Fatalf("Render() = %q after change, want v2", render())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
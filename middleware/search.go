@@ -0,0 +1,262 @@
+package middleware
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/GreatSir/realclouds_go/models"
+	"github.com/GreatSir/realclouds_go/utils"
+	"github.com/go-ego/gse"
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	//SEARCH_PREFIX *
+	SEARCH_PREFIX = "search"
+
+	//DEFAULT_SEARCH_MAX_PREFIX 前缀索引的最大长度(字符)
+	DEFAULT_SEARCH_MAX_PREFIX = 10
+
+	//DEFAULT_SEARCH_QUERY_TTL 多词查询结果缓存时间(秒)
+	DEFAULT_SEARCH_QUERY_TTL = 30
+)
+
+//SearchField 索引字段及权重
+type SearchField struct {
+	Text   string
+	Weight float64
+}
+
+//SearchHit 搜索结果
+type SearchHit struct {
+	ID    string  `json:"id" xml:"id"`
+	Score float64 `json:"score" xml:"score"`
+}
+
+//SearchIndex 基于 Redis 有序集合的倒排索引
+//索引词包括分词结果、词前缀、拼音全拼及首字母前缀, 支持 "bj" => "北京" 之类的查询
+type SearchIndex struct {
+	Name      string
+	Redis     *Redis
+	Segmenter *gse.Segmenter
+	MaxPrefix int
+	QueryTTL  int
+}
+
+//NewSearchIndex *
+func NewSearchIndex(name string, r *Redis, segmenter *gse.Segmenter) *SearchIndex {
+	return &SearchIndex{
+		Name:      strings.TrimSpace(name),
+		Redis:     r,
+		Segmenter: segmenter,
+		MaxPrefix: DEFAULT_SEARCH_MAX_PREFIX,
+		QueryTTL:  DEFAULT_SEARCH_QUERY_TTL,
+	}
+}
+
+//IndexModel 索引 Model 的 Name(权重 2) 和 Description(权重 1), 以及额外字段
+func (s *SearchIndex) IndexModel(model *models.Model, fields ...SearchField) error {
+	fields = append([]SearchField{
+		{Text: model.Name, Weight: 2},
+		{Text: model.Description, Weight: 1},
+	}, fields...)
+	return s.Index(model.ID, fields...)
+}
+
+//Index 索引文档, 会先删除旧的索引
+func (s *SearchIndex) Index(id string, fields ...SearchField) error {
+	id = strings.TrimSpace(id)
+
+	tokens := make(map[string]float64)
+	for _, field := range fields {
+		for token, score := range s.Tokens(field.Text, field.Weight) {
+			tokens[token] += score
+		}
+	}
+
+	conn := s.Redis.RedisPool.Get()
+	defer conn.Close()
+	if err := conn.Err(); err != nil {
+		return err
+	}
+
+	if err := s.remove(conn, id); nil != err {
+		return err
+	}
+
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	docArgs := redis.Args{}.Add(s.key("d", id))
+
+	conn.Send("MULTI")
+	for token, score := range tokens {
+		conn.Send("ZADD", s.key("t", token), score, id)
+		docArgs = docArgs.Add(token)
+	}
+	conn.Send("SADD", docArgs...)
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+//Remove 删除文档索引
+func (s *SearchIndex) Remove(id string) error {
+	conn := s.Redis.RedisPool.Get()
+	defer conn.Close()
+	if err := conn.Err(); err != nil {
+		return err
+	}
+	return s.remove(conn, strings.TrimSpace(id))
+}
+
+//Search 查询, 多个词之间为 AND 关系, 按相关度倒序
+func (s *SearchIndex) Search(query string, offset, limit int) (hits []SearchHit, total int, err error) {
+	hits = make([]SearchHit, 0)
+
+	terms := s.queryTerms(query)
+	if len(terms) == 0 || limit < 1 {
+		return
+	}
+
+	conn := s.Redis.RedisPool.Get()
+	defer conn.Close()
+	if err = conn.Err(); err != nil {
+		return
+	}
+
+	key := s.key("t", terms[0])
+	if len(terms) > 1 {
+		key = s.key("q", utils.StringUtils(strings.Join(terms, " ")).MD5())
+
+		exists, err := redis.Bool(conn.Do("EXISTS", key))
+		if nil != err {
+			return hits, 0, err
+		}
+		if !exists {
+			args := redis.Args{}.Add(key, len(terms))
+			for _, term := range terms {
+				args = args.Add(s.key("t", term))
+			}
+			args = args.Add("AGGREGATE", "SUM")
+
+			conn.Send("MULTI")
+			conn.Send("ZINTERSTORE", args...)
+			conn.Send("EXPIRE", key, s.QueryTTL)
+			if _, err = conn.Do("EXEC"); nil != err {
+				return hits, 0, err
+			}
+		}
+	}
+
+	if total, err = redis.Int(conn.Do("ZCARD", key)); nil != err {
+		return
+	}
+
+	values, err := redis.Values(conn.Do("ZREVRANGE", key, offset, offset+limit-1, "WITHSCORES"))
+	if nil != err {
+		return
+	}
+
+	for i := 0; i+1 < len(values); i += 2 {
+		id, _ := redis.String(values[i], nil)
+		score, _ := redis.Float64(values[i+1], nil)
+		hits = append(hits, SearchHit{ID: id, Score: score})
+	}
+	return
+}
+
+//Tokens 生成索引词及得分: 原词得分为 weight, 拼音全拼/首字母为 0.8 weight, 前缀为 0.5 weight
+func (s *SearchIndex) Tokens(text string, weight float64) map[string]float64 {
+	tokens := make(map[string]float64)
+
+	for _, word := range s.words(text, true) {
+		tokens[word] += weight
+		s.addPrefixes(tokens, word, weight*0.5)
+
+		for _, py := range []string{utils.StringUtils(word).PinYinSpell(), utils.StringUtils(word).PinYinInitials()} {
+			if len(py) == 0 || py == word {
+				continue
+			}
+			tokens[py] += weight * 0.8
+			s.addPrefixes(tokens, py, weight*0.5)
+		}
+	}
+	return tokens
+}
+
+func (s *SearchIndex) addPrefixes(tokens map[string]float64, word string, score float64) {
+	n := 0
+	for i := range word {
+		if i == 0 {
+			continue
+		}
+		n++
+		if n > s.MaxPrefix {
+			return
+		}
+		tokens[word[:i]] += score
+	}
+}
+
+func (s *SearchIndex) queryTerms(query string) []string {
+	terms := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, word := range s.words(query, false) {
+		if utf8.RuneCountInString(word) > s.MaxPrefix {
+			word = string([]rune(word)[:s.MaxPrefix])
+		}
+		if !seen[word] {
+			seen[word] = true
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
+
+//words 分词, 转小写并去除标点, HTML 内容会先去除标签
+func (s *SearchIndex) words(text string, searchMode bool) []string {
+	if strings.Contains(text, "<") {
+		_, text = utils.TrimHTML(text)
+	}
+	text = strings.ToLower(strings.TrimSpace(text))
+
+	var segments []string
+	switch {
+	case nil == s.Segmenter:
+		segments = strings.Fields(text)
+	case searchMode:
+		segments = s.Segmenter.CutSearch(text, true)
+	default:
+		segments = s.Segmenter.Cut(text, true)
+	}
+
+	words := make([]string, 0, len(segments))
+	for _, seg := range segments {
+		if word := utils.StringUtils(seg).CleanUP(); len(word) != 0 {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+func (s *SearchIndex) remove(conn redis.Conn, id string) error {
+	docKey := s.key("d", id)
+
+	tokens, err := redis.Strings(conn.Do("SMEMBERS", docKey))
+	if nil != err {
+		return err
+	}
+
+	conn.Send("MULTI")
+	for _, token := range tokens {
+		conn.Send("ZREM", s.key("t", token), id)
+	}
+	conn.Send("DEL", docKey)
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+func (s *SearchIndex) key(kind, val string) string {
+	return strings.Join([]string{SEARCH_PREFIX, s.Name, kind, val}, ":")
+}
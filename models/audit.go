@@ -0,0 +1,368 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pborman/uuid"
+)
+
+const (
+	//AuditActorKey gorm 设置项: 操作人
+	AuditActorKey = "audit:actor"
+
+	//AuditRequestIDKey gorm 设置项: 请求 ID
+	AuditRequestIDKey = "audit:request_id"
+
+	//AuditCreate *
+	AuditCreate = "create"
+	//AuditUpdate *
+	AuditUpdate = "update"
+	//AuditDelete *
+	AuditDelete = "delete"
+
+	auditBeforeKey = "audit:before"
+	//批量写入审计日志时每条 INSERT 的行数
+	auditBatchSize = 500
+)
+
+var (
+	auditTables  = make(map[string]bool)
+	auditMutex   sync.RWMutex
+	auditColumns = []string{"id", "entity_type", "entity_id", "action", "actor", "request_id", "before", "after", "diff", "created_at"}
+)
+
+//AuditLog 审计日志
+type AuditLog struct {
+	ID         string    `gorm:"primary_key;column:id;type:varchar(100)" json:"id,omitempty" xml:"id,omitempty"`
+	EntityType string    `sql:"index" gorm:"column:entity_type;type:varchar(100)" json:"entity_type,omitempty" xml:"entity_type,omitempty"`
+	EntityID   string    `sql:"index" gorm:"column:entity_id;type:varchar(100)" json:"entity_id,omitempty" xml:"entity_id,omitempty"`
+	Action     string    `gorm:"column:action;type:varchar(20)" json:"action,omitempty" xml:"action,omitempty"`
+	Actor      string    `sql:"index" gorm:"column:actor;type:varchar(100)" json:"actor,omitempty" xml:"actor,omitempty"`
+	RequestID  string    `gorm:"column:request_id;type:varchar(100)" json:"request_id,omitempty" xml:"request_id,omitempty"`
+	Before     string    `gorm:"column:before;type:longtext" json:"before,omitempty" xml:"before,omitempty"`
+	After      string    `gorm:"column:after;type:longtext" json:"after,omitempty" xml:"after,omitempty"`
+	Diff       string    `gorm:"column:diff;type:longtext" json:"diff,omitempty" xml:"diff,omitempty"`
+	CreatedAt  time.Time `sql:"index" gorm:"column:created_at;type:timestamp" json:"created_at,omitempty" xml:"created_at,omitempty"`
+}
+
+//AuditDiff 字段变化
+type AuditDiff struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+//TableName *
+func (AuditLog) TableName() string {
+	return "sys_audit_log"
+}
+
+//BeforeCreate ID处理
+func (d *AuditLog) BeforeCreate(scope *gorm.Scope) error {
+	uuidStr := uuid.NewRandom().String()
+	if err := scope.SetColumn("ID", uuidStr); nil != err {
+		return err
+	}
+	return nil
+}
+
+//RegisterAudit 为指定模型开启审计, 记录 create/update/delete
+//只记录带主键的单条记录操作及 Reorder/Move 等批量编号, 不带主键的批量条件更新及 raw SQL 不记录
+func RegisterAudit(db *gorm.DB, values ...interface{}) error {
+	if err := db.AutoMigrate(&AuditLog{}).Error; nil != err {
+		return err
+	}
+
+	auditMutex.Lock()
+	for _, v := range values {
+		auditTables[db.NewScope(v).TableName()] = true
+	}
+	auditMutex.Unlock()
+
+	callback := db.Callback()
+	if nil == callback.Create().Get("audit:create") {
+		callback.Create().After("gorm:create").Register("audit:create", auditCreateCallback)
+		callback.Update().Before("gorm:update").Register("audit:before_update", auditBeforeCallback)
+		callback.Update().After("gorm:update").Register("audit:update", auditAfterCallback(AuditUpdate))
+		callback.Delete().Before("gorm:delete").Register("audit:before_delete", auditBeforeCallback)
+		callback.Delete().After("gorm:delete").Register("audit:delete", auditAfterCallback(AuditDelete))
+	}
+	return nil
+}
+
+//WithAuditor 设置审计的操作人和请求 ID
+func WithAuditor(db *gorm.DB, actor, requestID string) *gorm.DB {
+	return db.Set(AuditActorKey, actor).Set(AuditRequestIDKey, requestID)
+}
+
+//FindAuditLogs 按实体查询审计日志, 按时间倒序
+func FindAuditLogs(db *gorm.DB, entityType, entityID string, page Page) (*PageResult[AuditLog], error) {
+	sql, args, err := And(Eq("entity_type", entityType), Eq("entity_id", entityID)).Build()
+	if nil != err {
+		return nil, err
+	}
+	db = db.Model(&AuditLog{}).Where(sql, args...)
+
+	total := 0
+	if err = db.Count(&total).Error; nil != err {
+		return nil, err
+	}
+
+	items := make([]AuditLog, 0)
+	if err = db.Order("created_at DESC").Offset(page.Offset()).Limit(page.Size).Find(&items).Error; nil != err {
+		return nil, err
+	}
+
+	return NewPageResult(page, total, items), nil
+}
+
+func audited(scope *gorm.Scope) bool {
+	if scope.HasError() || scope.PrimaryKeyZero() || scope.IndirectValue().Kind() != reflect.Struct {
+		return false
+	}
+	auditMutex.RLock()
+	defer auditMutex.RUnlock()
+	return auditTables[scope.TableName()]
+}
+
+func auditCreateCallback(scope *gorm.Scope) {
+	if !audited(scope) {
+		return
+	}
+	after, err := json.Marshal(scope.Value)
+	if nil != err {
+		scope.Err(err)
+		return
+	}
+	writeAuditLog(scope, AuditCreate, nil, after)
+}
+
+func auditBeforeCallback(scope *gorm.Scope) {
+	if !audited(scope) {
+		return
+	}
+	before, err := loadAuditRow(scope)
+	if nil != err {
+		scope.Err(err)
+		return
+	}
+	scope.InstanceSet(auditBeforeKey, before)
+}
+
+func auditAfterCallback(action string) func(scope *gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		if !audited(scope) || scope.DB().RowsAffected == 0 {
+			return
+		}
+
+		var before []byte
+		if v, ok := scope.InstanceGet(auditBeforeKey); ok {
+			before, _ = v.([]byte)
+		}
+
+		after, err := loadAuditRow(scope)
+		if nil != err {
+			scope.Err(err)
+			return
+		}
+		writeAuditLog(scope, action, before, after)
+	}
+}
+
+//auditBatch 按主键批量更新时记录审计, update 执行前后各读取一次 ids 对应的记录, 审计日志分批写入
+//update 应使用主键为零值的模型, 避免逐条审计回调
+func auditBatch(db *gorm.DB, value interface{}, ids []string, update func() error) error {
+	scope := db.NewScope(value)
+	auditMutex.RLock()
+	enabled := auditTables[scope.TableName()]
+	auditMutex.RUnlock()
+	if !enabled || len(ids) == 0 {
+		return update()
+	}
+
+	before, err := loadAuditRows(scope, ids)
+	if nil != err {
+		return err
+	}
+	if err = update(); nil != err {
+		return err
+	}
+	after, err := loadAuditRows(scope, ids)
+	if nil != err {
+		return err
+	}
+
+	var actor, requestID string
+	if v, ok := db.Get(AuditActorKey); ok {
+		actor = auditString(v)
+	}
+	if v, ok := db.Get(AuditRequestIDKey); ok {
+		requestID = auditString(v)
+	}
+
+	logs := make([]*AuditLog, 0, len(ids))
+	now := time.Now()
+	for _, id := range ids {
+		diff, err := auditDiff(before[id], after[id])
+		if nil != err {
+			return err
+		}
+		logs = append(logs, &AuditLog{
+			ID:         uuid.NewRandom().String(),
+			EntityType: scope.TableName(),
+			EntityID:   id,
+			Action:     AuditUpdate,
+			Actor:      actor,
+			RequestID:  requestID,
+			Before:     string(before[id]),
+			After:      string(after[id]),
+			Diff:       string(diff),
+			CreatedAt:  now,
+		})
+	}
+	return insertAuditLogs(db, logs)
+}
+
+//loadAuditRows 按主键读取多条记录(包含软删除), 返回主键到 JSON 的映射
+func loadAuditRows(scope *gorm.Scope, ids []string) (map[string][]byte, error) {
+	rows := reflect.New(reflect.SliceOf(reflect.PtrTo(scope.GetModelStruct().ModelType)))
+	if err := scope.NewDB().Unscoped().Where(scope.Quote(scope.PrimaryKey())+" IN (?)", ids).Find(rows.Interface()).Error; nil != err {
+		return nil, err
+	}
+
+	data := make(map[string][]byte, rows.Elem().Len())
+	for i := 0; i < rows.Elem().Len(); i++ {
+		row := rows.Elem().Index(i).Interface()
+		b, err := json.Marshal(row)
+		if nil != err {
+			return nil, err
+		}
+		data[auditString(scope.New(row).PrimaryKeyValue())] = b
+	}
+	return data, nil
+}
+
+//insertAuditLogs 多行 INSERT 写入审计日志
+func insertAuditLogs(db *gorm.DB, logs []*AuditLog) error {
+	scope := db.NewScope(&AuditLog{})
+	columns := make([]string, len(auditColumns))
+	for i, column := range auditColumns {
+		columns[i] = scope.Quote(column)
+	}
+	insert := "INSERT INTO " + scope.QuotedTableName() + " (" + strings.Join(columns, ", ") + ") VALUES "
+	for start := 0; start < len(logs); start += auditBatchSize {
+		end := start + auditBatchSize
+		if end > len(logs) {
+			end = len(logs)
+		}
+
+		var buf bytes.Buffer
+		buf.WriteString(insert)
+		args := make([]interface{}, 0, (end-start)*10)
+		for i, data := range logs[start:end] {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString("(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+			args = append(args, data.ID, data.EntityType, data.EntityID, data.Action, data.Actor,
+				data.RequestID, data.Before, data.After, data.Diff, data.CreatedAt)
+		}
+
+		if err := db.Exec(buf.String(), args...).Error; nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+//auditUpdate 记录未经审计回调的更新, before 为更新前的记录
+func auditUpdate(scope *gorm.Scope, before []byte) error {
+	after, err := loadAuditRow(scope)
+	if nil != err {
+		return err
+	}
+	writeAuditLog(scope, AuditUpdate, before, after)
+	return scope.DB().Error
+}
+
+//loadAuditRow 按主键读取当前记录(包含软删除), 不存在时返回 nil
+func loadAuditRow(scope *gorm.Scope) ([]byte, error) {
+	row := reflect.New(scope.GetModelStruct().ModelType).Interface()
+	db := scope.NewDB().Unscoped().Where(scope.PrimaryKey()+" = ?", scope.PrimaryKeyValue()).First(row)
+	if db.RecordNotFound() {
+		return nil, nil
+	}
+	if nil != db.Error {
+		return nil, db.Error
+	}
+	return json.Marshal(row)
+}
+
+func writeAuditLog(scope *gorm.Scope, action string, before, after []byte) {
+	data := &AuditLog{
+		EntityType: scope.TableName(),
+		EntityID:   auditString(scope.PrimaryKeyValue()),
+		Action:     action,
+		Before:     string(before),
+		After:      string(after),
+	}
+
+	if v, ok := scope.Get(AuditActorKey); ok {
+		data.Actor = auditString(v)
+	}
+	if v, ok := scope.Get(AuditRequestIDKey); ok {
+		data.RequestID = auditString(v)
+	}
+
+	diff, err := auditDiff(before, after)
+	if nil != err {
+		scope.Err(err)
+		return
+	}
+	data.Diff = string(diff)
+
+	if err := scope.NewDB().Create(data).Error; nil != err {
+		scope.Err(err)
+	}
+}
+
+//auditDiff 对比 JSON 顶层字段, 只保留变化的字段
+func auditDiff(before, after []byte) ([]byte, error) {
+	beforeMap, afterMap := make(map[string]interface{}), make(map[string]interface{})
+	if len(before) != 0 {
+		if err := json.Unmarshal(before, &beforeMap); nil != err {
+			return nil, err
+		}
+	}
+	if len(after) != 0 {
+		if err := json.Unmarshal(after, &afterMap); nil != err {
+			return nil, err
+		}
+	}
+
+	diff := make(map[string]AuditDiff)
+	for k, v := range beforeMap {
+		if !reflect.DeepEqual(v, afterMap[k]) {
+			diff[k] = AuditDiff{Before: v, After: afterMap[k]}
+		}
+	}
+	for k, v := range afterMap {
+		if _, ok := beforeMap[k]; !ok {
+			diff[k] = AuditDiff{After: v}
+		}
+	}
+	return json.Marshal(diff)
+}
+
+func auditString(v interface{}) string {
+	if nil == v {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
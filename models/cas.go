@@ -0,0 +1,105 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/jinzhu/gorm"
+)
+
+//CasColumn 乐观锁版本列
+const CasColumn = "cas_column"
+
+//ConflictError 乐观锁冲突, 记录已被其他请求修改
+type ConflictError struct {
+	Table string
+	ID    interface{}
+	Cas   int64
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("Optimistic lock conflict: %s id %v cas %d.", e.Table, e.ID, e.Cas)
+}
+
+//IsConflict *
+func IsConflict(err error) bool {
+	var conflict *ConflictError
+	return errors.As(err, &conflict)
+}
+
+//UpdateCAS 乐观锁更新, cas 同时作为 WHERE 条件并自增, 成功后 *cas 加 1
+//只按主要主键(复合主键时为 id)定位记录, 其他主键列(如 md5)可随本次更新修改; cas 为 NULL 的旧数据视为 0
+//attrs 为空时更新 value 的所有非零值字段; 影响行数为 0 时返回 *ConflictError 或 gorm.ErrRecordNotFound
+func UpdateCAS(db *gorm.DB, value interface{}, cas *int64, attrs map[string]interface{}) error {
+	scope := db.NewScope(value)
+	if scope.PrimaryKeyZero() {
+		return ErrMissingID
+	}
+
+	//不修改调用方的 attrs
+	values := make(map[string]interface{}, len(attrs)+1)
+	if nil == attrs {
+		attrs = NonBlankAttrs(db, value)
+	}
+	for k, v := range attrs {
+		values[k] = v
+	}
+	values[CasColumn] = gorm.Expr("COALESCE("+CasColumn+", 0) + ?", 1)
+
+	//主键为零值的模型, gorm 不会把所有主键列加入 WHERE 条件; 审计回调因此跳过, 由 auditUpdate 记录
+	model := reflect.New(scope.GetModelStruct().ModelType).Interface()
+	where := scope.Quote(scope.PrimaryKey()) + " = ?"
+
+	var before []byte
+	audit := audited(scope)
+	if audit {
+		var err error
+		if before, err = loadAuditRow(scope); nil != err {
+			return err
+		}
+	}
+
+	result := db.Model(model).Where(where+" AND COALESCE("+CasColumn+", 0) = ?", scope.PrimaryKeyValue(), *cas).Updates(values)
+	if nil != result.Error {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		count := 0
+		if err := db.Model(model).Where(where, scope.PrimaryKeyValue()).Count(&count).Error; nil != err {
+			return err
+		}
+		if count == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return &ConflictError{Table: scope.TableName(), ID: scope.PrimaryKeyValue(), Cas: *cas}
+	}
+
+	if field, ok := db.NewScope(model).FieldByName("UpdatedAt"); ok && !field.IsBlank {
+		if err := scope.SetColumn("UpdatedAt", field.Field.Interface()); nil != err {
+			return err
+		}
+	}
+	*cas++
+
+	if audit {
+		return auditUpdate(scope, before)
+	}
+	return nil
+}
+
+//NonBlankAttrs 非零值字段, 不包含主要主键和 cas_column; 复合主键中的其他主键列可以更新
+func NonBlankAttrs(db *gorm.DB, value interface{}) map[string]interface{} {
+	scope := db.NewScope(value)
+	primaryKey := scope.PrimaryKey()
+
+	attrs := make(map[string]interface{})
+	for _, field := range scope.Fields() {
+		if field.IsBlank || field.IsIgnored || !field.IsNormal || field.DBName == primaryKey || field.DBName == CasColumn {
+			continue
+		}
+		attrs[field.DBName] = field.Field.Interface()
+	}
+	return attrs
+}
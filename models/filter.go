@@ -0,0 +1,163 @@
+package models
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+//Filter 查询条件
+type Filter interface {
+	//Build 生成 SQL 片段及参数, 空条件返回空字符串
+	Build() (string, []interface{}, error)
+}
+
+type rawFilter struct {
+	sql  string
+	args []interface{}
+	err  error
+}
+
+func (f rawFilter) Build() (string, []interface{}, error) {
+	return f.sql, f.args, f.err
+}
+
+type groupFilter struct {
+	op      string
+	filters []Filter
+}
+
+func (f groupFilter) Build() (string, []interface{}, error) {
+	parts := make([]string, 0, len(f.filters))
+	args := make([]interface{}, 0)
+
+	for _, filter := range f.filters {
+		if nil == filter {
+			continue
+		}
+		sql, vals, err := filter.Build()
+		if nil != err {
+			return "", nil, err
+		}
+		if len(sql) == 0 {
+			continue
+		}
+		parts = append(parts, sql)
+		args = append(args, vals...)
+	}
+
+	switch len(parts) {
+	case 0:
+		return "", nil, nil
+	case 1:
+		return parts[0], args, nil
+	}
+	return "(" + strings.Join(parts, " "+f.op+" ") + ")", args, nil
+}
+
+func columnFilter(column, sql string, args ...interface{}) Filter {
+	column = strings.TrimSpace(column)
+	if !ValidColumn(column) {
+		return rawFilter{err: fmt.Errorf("Invalid filter column: %s", column)}
+	}
+	return rawFilter{sql: fmt.Sprintf(sql, column), args: args}
+}
+
+//Eq column = value
+func Eq(column string, value interface{}) Filter {
+	return columnFilter(column, "%s = ?", value)
+}
+
+//Ne column <> value
+func Ne(column string, value interface{}) Filter {
+	return columnFilter(column, "%s <> ?", value)
+}
+
+//In column IN (values), values 为切片, 空切片视为无条件
+func In(column string, values interface{}) Filter {
+	v := reflect.ValueOf(values)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return rawFilter{err: fmt.Errorf("Invalid IN values for column: %s", column)}
+	}
+	if v.Len() == 0 {
+		return rawFilter{}
+	}
+	return columnFilter(column, "%s IN (?)", values)
+}
+
+//Like column LIKE %keyword%, 自动转义通配符
+func Like(column, keyword string) Filter {
+	return columnFilter(column, "%s LIKE ? ESCAPE '!'", "%"+EscapeLike(keyword)+"%")
+}
+
+//Prefix column LIKE keyword%, 自动转义通配符
+func Prefix(column, keyword string) Filter {
+	return columnFilter(column, "%s LIKE ? ESCAPE '!'", EscapeLike(keyword)+"%")
+}
+
+//Range min <= column <= max, nil 表示不限
+func Range(column string, min, max interface{}) Filter {
+	filters := make([]Filter, 0, 2)
+	if nil != min {
+		filters = append(filters, columnFilter(column, "%s >= ?", min))
+	}
+	if nil != max {
+		filters = append(filters, columnFilter(column, "%s <= ?", max))
+	}
+	return And(filters...)
+}
+
+//And 以 AND 组合, 自动加括号
+func And(filters ...Filter) Filter {
+	return groupFilter{op: "AND", filters: filters}
+}
+
+//Or 以 OR 组合, 自动加括号, 不会影响其他条件
+func Or(filters ...Filter) Filter {
+	return groupFilter{op: "OR", filters: filters}
+}
+
+//EscapeLike 转义 LIKE 通配符, 转义字符为 '!'
+func EscapeLike(keyword string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(keyword)
+}
+
+//Where 将条件编译为 gorm scope
+func Where(filters ...Filter) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		sql, args, err := And(filters...).Build()
+		if nil != err {
+			db = db.Where("1 = 0")
+			db.AddError(err)
+			return db
+		}
+		if len(sql) == 0 {
+			return db
+		}
+		return db.Where(sql, args...)
+	}
+}
+
+//ParseFilters 将 key/value 参数转换为条件, 未知 key 或参数个数为奇数时返回错误, 空值忽略
+func ParseFilters(args []string, fields map[string]func(val string) Filter) (Filter, error) {
+	if len(args)%2 != 0 {
+		return nil, fmt.Errorf("Invalid filter params length: %d", len(args))
+	}
+
+	filters := make([]Filter, 0, len(args)/2)
+	for i := 0; i < len(args); i = i + 2 {
+		key, val := strings.TrimSpace(args[i]), strings.TrimSpace(args[i+1])
+		fn, ok := fields[key]
+		if !ok {
+			return nil, fmt.Errorf("Unknown filter param: %s", key)
+		}
+		if len(val) == 0 {
+			continue
+		}
+		filters = append(filters, fn(val))
+	}
+
+	return And(filters...), nil
+}
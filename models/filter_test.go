@@ -0,0 +1,55 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFilterBuild(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		sql    string
+		args   []interface{}
+		err    bool
+	}{
+		{"eq", Eq("name", "a"), "name = ?", []interface{}{"a"}, false},
+		{"ne", Ne("name", "a"), "name <> ?", []interface{}{"a"}, false},
+		{"in", In("id", []string{"1", "2"}), "id IN (?)", []interface{}{[]string{"1", "2"}}, false},
+		{"in empty", In("id", []string{}), "", nil, false},
+		{"in invalid", In("id", "1"), "", nil, true},
+		{"like", Like("name", "a_%!"), "name LIKE ? ESCAPE '!'", []interface{}{"%a!_!%!!%"}, false},
+		{"prefix", Prefix("path", "/1/"), "path LIKE ? ESCAPE '!'", []interface{}{"/1/%"}, false},
+		{"range", Range("age", 1, 2), "(age >= ? AND age <= ?)", []interface{}{1, 2}, false},
+		{"range min", Range("age", 1, nil), "age >= ?", []interface{}{1}, false},
+		{"range none", Range("age", nil, nil), "", nil, false},
+		{"and", And(Eq("a", 1), nil, In("b", []int{}), Eq("c", 2)), "(a = ? AND c = ?)", []interface{}{1, 2}, false},
+		{"or", And(Eq("a", 1), Or(Eq("b", 2), Eq("c", 3))), "(a = ? AND (b = ? OR c = ?))", []interface{}{1, 2, 3}, false},
+		{"empty", And(), "", nil, false},
+		{"invalid column", Eq("a; DROP TABLE t", 1), "", nil, true},
+		{"nested error", Or(Eq("a", 1), Eq("b c", 2)), "", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, args, err := tt.filter.Build()
+			if tt.err {
+				if nil == err {
+					t.Fatalf("Build() error = nil, want error")
+				}
+				return
+			}
+			if nil != err {
+				t.Fatalf("Build() error = %v", err)
+			}
+			if sql != tt.sql {
+				t.Errorf("Build() sql = %q, want %q", sql, tt.sql)
+			}
+			if len(args) != 0 || len(tt.args) != 0 {
+				if !reflect.DeepEqual(args, tt.args) {
+					t.Errorf("Build() args = %v, want %v", args, tt.args)
+				}
+			}
+		})
+	}
+}
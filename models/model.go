@@ -70,13 +70,18 @@ func AutoMigrate(c echo.Context, schema ...interface{}) error {
 }
 
 //ParamsToMaps *
+//
+//Deprecated: 使用 ParseFilters, 参数个数为奇数时最后一个 key 的值为空
 func ParamsToMaps(args []string) map[string]string {
 
 	params := make(map[string]string)
 
 	for i := 0; i < len(args); i = i + 2 {
 		key := args[i]
-		val := args[i+1]
+		val := ""
+		if i+1 < len(args) {
+			val = args[i+1]
+		}
 		params[key] = val
 	}
 
@@ -0,0 +1,156 @@
+package models
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+type sortRow struct {
+	ID         string `gorm:"column:id"`
+	SortNumber int    `gorm:"column:sort_number"`
+}
+
+//MoveBefore 在 scope 范围内将 id 移动到 targetID 之前
+func (r *Repository[T, PT]) MoveBefore(id, targetID string, scope ...Filter) error {
+	return r.move(id, targetID, 0, scope)
+}
+
+//MoveAfter 在 scope 范围内将 id 移动到 targetID 之后
+func (r *Repository[T, PT]) MoveAfter(id, targetID string, scope ...Filter) error {
+	return r.move(id, targetID, 1, scope)
+}
+
+//MoveToTop 在 scope 范围内将 id 移动到最前
+func (r *Repository[T, PT]) MoveToTop(id string, scope ...Filter) error {
+	id = strings.TrimSpace(id)
+	return r.reorder(scope, func(ids []string) ([]string, error) {
+		ids, ok := removeID(ids, id)
+		if !ok {
+			return nil, gorm.ErrRecordNotFound
+		}
+		return append([]string{id}, ids...), nil
+	})
+}
+
+//MoveToBottom 在 scope 范围内将 id 移动到最后
+func (r *Repository[T, PT]) MoveToBottom(id string, scope ...Filter) error {
+	id = strings.TrimSpace(id)
+	return r.reorder(scope, func(ids []string) ([]string, error) {
+		ids, ok := removeID(ids, id)
+		if !ok {
+			return nil, gorm.ErrRecordNotFound
+		}
+		return append(ids, id), nil
+	})
+}
+
+//Reorder 批量排序, ids 按新顺序占用它们原有的位置, 其余记录位置不变
+func (r *Repository[T, PT]) Reorder(ids []string, scope ...Filter) error {
+	return r.reorder(scope, func(current []string) ([]string, error) {
+		wanted := make(map[string]bool, len(ids))
+		for _, id := range ids {
+			wanted[strings.TrimSpace(id)] = true
+		}
+
+		result := make([]string, len(current))
+		next := 0
+		for i, id := range current {
+			if !wanted[id] {
+				result[i] = id
+				continue
+			}
+			result[i] = strings.TrimSpace(ids[next])
+			next++
+		}
+
+		if next != len(ids) {
+			return nil, gorm.ErrRecordNotFound
+		}
+		return result, nil
+	})
+}
+
+func (r *Repository[T, PT]) move(id, targetID string, offset int, scope []Filter) error {
+	id, targetID = strings.TrimSpace(id), strings.TrimSpace(targetID)
+	if id == targetID {
+		return nil
+	}
+
+	return r.reorder(scope, func(ids []string) ([]string, error) {
+		ids, ok := removeID(ids, id)
+		if !ok {
+			return nil, gorm.ErrRecordNotFound
+		}
+		for i, v := range ids {
+			if v == targetID {
+				i += offset
+				return append(ids[:i], append([]string{id}, ids[i:]...)...), nil
+			}
+		}
+		return nil, gorm.ErrRecordNotFound
+	})
+}
+
+//reorder 在事务中锁定 scope 内的记录, 按 fn 返回的顺序重新编号, 只更新编号变化的记录
+//通过模型 scope 一次更新, 缓存按 ID 失效, 审计日志批量写入
+func (r *Repository[T, PT]) reorder(scope []Filter, fn func(ids []string) ([]string, error)) error {
+	return Transaction(r.DB, func(tx *gorm.DB) error {
+		rows := make([]sortRow, 0)
+		if err := tx.Model(PT(new(T))).Scopes(Where(scope...)).
+			Set("gorm:query_option", "FOR UPDATE").
+			Select("id, sort_number").
+			Order("sort_number ASC").Order("created_at ASC").Order("id ASC").
+			Scan(&rows).Error; nil != err {
+			return err
+		}
+
+		ids := make([]string, len(rows))
+		for i, row := range rows {
+			ids[i] = row.ID
+		}
+
+		ids, err := fn(ids)
+		if nil != err {
+			return err
+		}
+
+		current := make(map[string]int, len(rows))
+		for _, row := range rows {
+			current[row.ID] = row.SortNumber
+		}
+
+		var buf bytes.Buffer
+		args := make([]interface{}, 0)
+		changed := make([]string, 0)
+		for i, id := range ids {
+			if current[id] == i+1 {
+				continue
+			}
+			buf.WriteString(" WHEN ? THEN ?")
+			args = append(args, id, i+1)
+			changed = append(changed, id)
+		}
+
+		if len(changed) == 0 {
+			return nil
+		}
+
+		return auditBatch(tx, PT(new(T)), changed, func() error {
+			return tx.Model(PT(new(T))).Where("id IN (?)", changed).UpdateColumns(map[string]interface{}{
+				"sort_number": gorm.Expr("CASE id"+buf.String()+" END", args...),
+				CasColumn:     gorm.Expr("COALESCE("+CasColumn+", 0) + ?", 1),
+			}).Error
+		})
+	})
+}
+
+func removeID(ids []string, id string) ([]string, bool) {
+	for i, v := range ids {
+		if v == id {
+			return append(ids[:i:i], ids[i+1:]...), true
+		}
+	}
+	return ids, false
+}
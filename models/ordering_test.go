@@ -0,0 +1,110 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+type testItem struct {
+	Model
+}
+
+func (testItem) TableName() string {
+	return "test_item"
+}
+
+func openTestDB(t *testing.T, values ...interface{}) *gorm.DB {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if nil != err {
+		t.Fatalf("gorm.Open() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	//sqlite 不支持 FOR UPDATE
+	db.Callback().Query().Before("gorm:query").Register("test:query_option", func(scope *gorm.Scope) {
+		scope.Set("gorm:query_option", "")
+	})
+
+	if err := db.AutoMigrate(values...).Error; nil != err {
+		t.Fatalf("AutoMigrate() error = %v", err)
+	}
+	return db
+}
+
+func TestReorder(t *testing.T) {
+	db := openTestDB(t, &testItem{})
+	if err := RegisterAudit(db, &testItem{}); nil != err {
+		t.Fatalf("RegisterAudit() error = %v", err)
+	}
+
+	repo := NewRepository[testItem](db)
+	//BeforeCreate 生成 ID, 以 Name 标识记录
+	ids := make(map[string]string)
+	for i, name := range []string{"a", "b", "c", "d"} {
+		item := &testItem{Model{Name: name, SortNumber: i + 1}}
+		if err := db.Create(item).Error; nil != err {
+			t.Fatal(err)
+		}
+		ids[name] = item.ID
+	}
+	//创建时的审计日志不计入
+	if err := db.Delete(&AuditLog{}).Error; nil != err {
+		t.Fatal(err)
+	}
+
+	order := func() []string {
+		items := make([]testItem, 0)
+		if err := db.Order("sort_number ASC").Find(&items).Error; nil != err {
+			t.Fatal(err)
+		}
+		names := make([]string, len(items))
+		for i, item := range items {
+			names[i] = item.Name
+		}
+		return names
+	}
+
+	tests := []struct {
+		name    string
+		fn      func() error
+		want    []string
+		audited int
+	}{
+		{"to top", func() error { return repo.MoveToTop(ids["d"]) }, []string{"d", "a", "b", "c"}, 4},
+		{"unchanged", func() error { return repo.MoveToTop(ids["d"]) }, []string{"d", "a", "b", "c"}, 0},
+		{"after", func() error { return repo.MoveAfter(ids["d"], ids["b"]) }, []string{"a", "b", "d", "c"}, 3},
+		{"reorder", func() error { return repo.Reorder([]string{ids["c"], ids["a"]}) }, []string{"c", "b", "d", "a"}, 2},
+	}
+
+	for _, tt := range tests {
+		before := 0
+		db.Model(&AuditLog{}).Count(&before)
+
+		if err := tt.fn(); nil != err {
+			t.Fatalf("%s: error = %v", tt.name, err)
+		}
+		if got := order(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: order = %v, want %v", tt.name, got, tt.want)
+		}
+
+		after := 0
+		db.Model(&AuditLog{}).Count(&after)
+		if after-before != tt.audited {
+			t.Errorf("%s: audit logs = %d, want %d", tt.name, after-before, tt.audited)
+		}
+	}
+
+	total, withDiff := 0, 0
+	db.Model(&AuditLog{}).Where("action = ?", AuditUpdate).Count(&total)
+	db.Model(&AuditLog{}).Where("action = ? AND diff LIKE ?", AuditUpdate, "%sort_number%").Count(&withDiff)
+	if total != 9 || withDiff != total {
+		t.Errorf("audit logs = %d, with sort_number diff = %d, want 9", total, withDiff)
+	}
+
+	if err := repo.MoveToTop("missing"); !gorm.IsRecordNotFoundError(err) {
+		t.Errorf("MoveToTop() missing error = %v, want record not found", err)
+	}
+}
@@ -0,0 +1,269 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+const (
+	//DefaultPageSize 默认每页数量
+	DefaultPageSize = 20
+
+	//MaxPageSize 默认每页最大数量
+	MaxPageSize = 100
+)
+
+//PageOpt 分页参数解析配置
+type PageOpt struct {
+	DefaultSize  int
+	MaxSize      int
+	Sortable     []string
+	DefaultSorts []Sort
+}
+
+//Page 分页请求
+type Page struct {
+	Number int    `json:"page" xml:"page"`
+	Size   int    `json:"size" xml:"size"`
+	Sorts  []Sort `json:"sorts,omitempty" xml:"sorts,omitempty"`
+}
+
+//PageResult 分页结果
+type PageResult[T any] struct {
+	Total int `json:"total" xml:"total"`
+	Pages int `json:"pages" xml:"pages"`
+	Page  int `json:"page" xml:"page"`
+	Size  int `json:"size" xml:"size"`
+	Items []T `json:"items" xml:"items"`
+}
+
+//Cursor 游标, 按 created_at/id 定位, Asc 为生成游标时的排序方向
+type Cursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        string    `json:"i"`
+	Asc       bool      `json:"a,omitempty"`
+}
+
+//CursorPage 游标分页请求
+type CursorPage struct {
+	After string `json:"cursor,omitempty" xml:"cursor,omitempty"`
+	Size  int    `json:"size" xml:"size"`
+	Asc   bool   `json:"asc" xml:"asc"`
+}
+
+//CursorResult 游标分页结果
+type CursorResult[T any] struct {
+	Items      []T    `json:"items" xml:"items"`
+	NextCursor string `json:"next_cursor,omitempty" xml:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more" xml:"has_more"`
+}
+
+//PageFromContext 从请求参数解析分页
+func PageFromContext(c echo.Context, opts ...PageOpt) (Page, error) {
+	return ParsePage(c.QueryParams(), opts...)
+}
+
+//ParsePage 解析 page, size, sort 参数, sort 形如 "-created_at,name", "-" 表示倒序
+func ParsePage(values url.Values, opts ...PageOpt) (page Page, err error) {
+	opt := pageOpt(opts)
+
+	page.Number, _ = strconv.Atoi(strings.TrimSpace(values.Get("page")))
+	if page.Number < 1 {
+		page.Number = 1
+	}
+
+	page.Size = pageSize(values.Get("size"), opt)
+
+	sortStr := strings.TrimSpace(values.Get("sort"))
+	if len(sortStr) == 0 {
+		page.Sorts = opt.DefaultSorts
+		return
+	}
+
+	allowed := make(map[string]bool)
+	for _, column := range opt.Sortable {
+		allowed[column] = true
+	}
+
+	for _, item := range strings.Split(sortStr, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		sort := Sort{Column: strings.TrimPrefix(item, "-"), Desc: strings.HasPrefix(item, "-")}
+		if !allowed[sort.Column] {
+			return page, fmt.Errorf("Invalid sort field: %s", sort.Column)
+		}
+		page.Sorts = append(page.Sorts, sort)
+	}
+	return
+}
+
+//Offset *
+func (p Page) Offset() int {
+	return ComputeOffset(p.Number, p.Size)
+}
+
+//ListOpt 转换为列表查询参数
+func (p Page) ListOpt(filters ...Filter) ListOpt {
+	return ListOpt{
+		Filters:    filters,
+		Sorts:      p.Sorts,
+		PageNumber: p.Number,
+		PageSize:   p.Size,
+	}
+}
+
+//NewPageResult *
+func NewPageResult[T any](page Page, total int, items []T) *PageResult[T] {
+	pages := 0
+	if page.Size > 0 {
+		pages = (total + page.Size - 1) / page.Size
+	}
+	if nil == items {
+		items = make([]T, 0)
+	}
+	return &PageResult[T]{
+		Total: total,
+		Pages: pages,
+		Page:  page.Number,
+		Size:  page.Size,
+		Items: items,
+	}
+}
+
+//CursorPageFromContext 从请求参数解析游标分页
+func CursorPageFromContext(c echo.Context, opts ...PageOpt) CursorPage {
+	return ParseCursorPage(c.QueryParams(), opts...)
+}
+
+//ParseCursorPage 解析 cursor, size, asc 参数
+func ParseCursorPage(values url.Values, opts ...PageOpt) CursorPage {
+	asc, _ := strconv.ParseBool(strings.TrimSpace(values.Get("asc")))
+	return CursorPage{
+		After: strings.TrimSpace(values.Get("cursor")),
+		Size:  pageSize(values.Get("size"), pageOpt(opts)),
+		Asc:   asc,
+	}
+}
+
+//Encode *
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+//DecodeCursor *
+func DecodeCursor(val string) (cursor Cursor, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(val))
+	if nil != err {
+		return cursor, fmt.Errorf("Invalid cursor: %v", err)
+	}
+	if err = json.Unmarshal(b, &cursor); nil != err {
+		return cursor, fmt.Errorf("Invalid cursor: %v", err)
+	}
+	return
+}
+
+//Page 分页查询
+func (r *Repository[T, PT]) Page(page Page, filters ...Filter) (*PageResult[T], error) {
+	opt := page.ListOpt(filters...)
+
+	total, err := r.Count(opt)
+	if nil != err {
+		return nil, err
+	}
+
+	items := make([]T, 0)
+	if total > page.Offset() {
+		if items, err = r.List(opt); nil != err {
+			return nil, err
+		}
+	}
+
+	return NewPageResult(page, total, items), nil
+}
+
+//Scroll 游标分页查询, 按 created_at, id 排序, 适用于大表
+func (r *Repository[T, PT]) Scroll(page CursorPage, filters ...Filter) (*CursorResult[T], error) {
+	if page.Size < 1 {
+		page.Size = DefaultPageSize
+	}
+
+	op, dir := "<", "DESC"
+	if page.Asc {
+		op, dir = ">", "ASC"
+	}
+
+	if len(page.After) != 0 {
+		cursor, err := DecodeCursor(page.After)
+		if nil != err {
+			return nil, err
+		}
+		if cursor.Asc != page.Asc {
+			return nil, fmt.Errorf("%s", "Invalid cursor: sort direction mismatch")
+		}
+		filters = append(filters, rawFilter{
+			sql:  fmt.Sprintf("(created_at %s ? OR (created_at = ? AND id %s ?))", op, op),
+			args: []interface{}{cursor.CreatedAt, cursor.CreatedAt, cursor.ID},
+		})
+	}
+
+	sql, args, err := And(filters...).Build()
+	if nil != err {
+		return nil, err
+	}
+
+	db := r.DB
+	if len(sql) != 0 {
+		db = db.Where(sql, args...)
+	}
+
+	items := make([]T, 0, page.Size+1)
+	if err = db.Order("created_at " + dir).Order("id " + dir).Limit(page.Size + 1).Find(&items).Error; nil != err {
+		return nil, err
+	}
+
+	result := &CursorResult[T]{Items: items}
+	if len(items) > page.Size {
+		result.Items = items[:page.Size]
+		result.HasMore = true
+	}
+	if result.HasMore {
+		last := PT(&result.Items[len(result.Items)-1]).GetModel()
+		result.NextCursor = Cursor{CreatedAt: last.CreatedAt, ID: last.ID, Asc: page.Asc}.Encode()
+	}
+	return result, nil
+}
+
+func pageOpt(opts []PageOpt) PageOpt {
+	var opt PageOpt
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	if opt.DefaultSize < 1 {
+		opt.DefaultSize = DefaultPageSize
+	}
+	if opt.MaxSize < 1 {
+		opt.MaxSize = MaxPageSize
+	}
+	return opt
+}
+
+func pageSize(val string, opt PageOpt) int {
+	size, err := strconv.Atoi(strings.TrimSpace(val))
+	if nil != err || size < 1 {
+		size = opt.DefaultSize
+	}
+	if size > opt.MaxSize {
+		size = opt.MaxSize
+	}
+	return size
+}
@@ -0,0 +1,143 @@
+package models
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParsePage(t *testing.T) {
+	opt := PageOpt{Sortable: []string{"name", "created_at"}, DefaultSorts: []Sort{{Column: "sort_number"}}, MaxSize: 50}
+
+	tests := []struct {
+		name  string
+		query string
+		page  Page
+		err   bool
+	}{
+		{"defaults", "", Page{Number: 1, Size: DefaultPageSize, Sorts: []Sort{{Column: "sort_number"}}}, false},
+		{"number and size", "page=3&size=10", Page{Number: 3, Size: 10, Sorts: []Sort{{Column: "sort_number"}}}, false},
+		{"max size", "page=0&size=500", Page{Number: 1, Size: 50, Sorts: []Sort{{Column: "sort_number"}}}, false},
+		{"asc", "sort=name", Page{Number: 1, Size: DefaultPageSize, Sorts: []Sort{{Column: "name"}}}, false},
+		{"desc", "sort=-created_at,name", Page{Number: 1, Size: DefaultPageSize, Sorts: []Sort{{Column: "created_at", Desc: true}, {Column: "name"}}}, false},
+		{"unknown sort", "sort=-password", Page{}, true},
+		{"injection", "sort=name%3BDROP+TABLE+t", Page{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, _ := url.ParseQuery(tt.query)
+			page, err := ParsePage(values, opt)
+			if tt.err {
+				if nil == err {
+					t.Fatalf("ParsePage() error = nil, want error")
+				}
+				return
+			}
+			if nil != err {
+				t.Fatalf("ParsePage() error = %v", err)
+			}
+			if !reflect.DeepEqual(page, tt.page) {
+				t.Errorf("ParsePage() = %+v, want %+v", page, tt.page)
+			}
+		})
+	}
+}
+
+func TestParseCursorPage(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		page  CursorPage
+	}{
+		{"defaults", "", CursorPage{Size: DefaultPageSize}},
+		{"asc", "cursor=abc&size=5&asc=true", CursorPage{After: "abc", Size: 5, Asc: true}},
+		{"desc", "asc=0", CursorPage{Size: DefaultPageSize}},
+		{"invalid asc", "asc=yes", CursorPage{Size: DefaultPageSize}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, _ := url.ParseQuery(tt.query)
+			if page := ParseCursorPage(values); page != tt.page {
+				t.Errorf("ParseCursorPage() = %+v, want %+v", page, tt.page)
+			}
+		})
+	}
+}
+
+func TestCursor(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		cursor Cursor
+	}{
+		{"desc", Cursor{CreatedAt: now, ID: "a"}},
+		{"asc", Cursor{CreatedAt: now, ID: "b", Asc: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor, err := DecodeCursor(tt.cursor.Encode())
+			if nil != err {
+				t.Fatalf("DecodeCursor() error = %v", err)
+			}
+			if !cursor.CreatedAt.Equal(tt.cursor.CreatedAt) || cursor.ID != tt.cursor.ID || cursor.Asc != tt.cursor.Asc {
+				t.Errorf("DecodeCursor() = %+v, want %+v", cursor, tt.cursor)
+			}
+		})
+	}
+
+	for _, val := range []string{"!!", "bm90IGpzb24"} {
+		if _, err := DecodeCursor(val); nil == err {
+			t.Errorf("DecodeCursor(%q) error = nil, want error", val)
+		}
+	}
+}
+
+func TestScroll(t *testing.T) {
+	db := openTestDB(t, &testItem{})
+	repo := NewRepository[testItem](db)
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, name := range []string{"a", "b", "c", "d", "e"} {
+		if err := db.Create(&testItem{Model{Name: name, CreatedAt: start.Add(time.Duration(i) * time.Minute)}}).Error; nil != err {
+			t.Fatal(err)
+		}
+	}
+
+	scroll := func(asc bool) []string {
+		names := make([]string, 0)
+		page := CursorPage{Size: 2, Asc: asc}
+		for {
+			result, err := repo.Scroll(page)
+			if nil != err {
+				t.Fatalf("Scroll() error = %v", err)
+			}
+			for _, item := range result.Items {
+				names = append(names, item.Name)
+			}
+			if !result.HasMore {
+				return names
+			}
+			page.After = result.NextCursor
+		}
+	}
+
+	if got, want := scroll(false), []string{"e", "d", "c", "b", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Scroll() desc = %v, want %v", got, want)
+	}
+	if got, want := scroll(true), []string{"a", "b", "c", "d", "e"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Scroll() asc = %v, want %v", got, want)
+	}
+
+	result, err := repo.Scroll(CursorPage{Size: 2})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if _, err = repo.Scroll(CursorPage{After: result.NextCursor, Size: 2, Asc: true}); nil == err {
+		t.Error("Scroll() with desc cursor and asc page error = nil, want direction mismatch")
+	}
+}
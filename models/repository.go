@@ -0,0 +1,223 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	//ErrMissingID 实体缺少 ID
+	ErrMissingID = errors.New("Missing entity id.")
+
+	columnRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+)
+
+//Entity 嵌入 Model 的实体约束
+type Entity[T any] interface {
+	*T
+	GetModel() *Model
+}
+
+//GetModel *
+func (d *Model) GetModel() *Model {
+	return d
+}
+
+//Sort 排序字段
+type Sort struct {
+	Column string `json:"column" xml:"column"`
+	Desc   bool   `json:"desc" xml:"desc"`
+}
+
+//ListOpt 列表查询参数
+type ListOpt struct {
+	Filters     []Filter
+	Scopes      []func(*gorm.DB) *gorm.DB
+	Sorts       []Sort
+	PageNumber  int
+	PageSize    int
+	WithDeleted bool
+}
+
+//Repository 基于 Model 的通用 CRUD
+type Repository[T any, PT Entity[T]] struct {
+	DB *gorm.DB
+}
+
+//NewRepository *
+func NewRepository[T any, PT Entity[T]](db *gorm.DB) *Repository[T, PT] {
+	return &Repository[T, PT]{DB: db}
+}
+
+//WithDB 使用指定连接(如事务)
+func (r *Repository[T, PT]) WithDB(db *gorm.DB) *Repository[T, PT] {
+	return &Repository[T, PT]{DB: db}
+}
+
+//Transaction 在事务中执行, 见 models.Transaction
+func (r *Repository[T, PT]) Transaction(fn func(repo *Repository[T, PT]) error) error {
+	return Transaction(r.DB, func(tx *gorm.DB) error {
+		return fn(r.WithDB(tx))
+	})
+}
+
+//Get 根据 ID 获取
+func (r *Repository[T, PT]) Get(id string) (data PT, err error) {
+	id = strings.TrimSpace(id)
+	if len(id) == 0 {
+		return nil, ErrMissingID
+	}
+
+	data = PT(new(T))
+	if err = r.DB.First(data, "id = ?", id).Error; nil != err {
+		return nil, err
+	}
+	return
+}
+
+//List 列表查询
+func (r *Repository[T, PT]) List(opts ...ListOpt) (data []T, err error) {
+	db, err := r.query(opts, true)
+	if nil != err {
+		return nil, err
+	}
+
+	data = make([]T, 0)
+	err = db.Find(&data).Error
+	return
+}
+
+//Count 统计数量
+func (r *Repository[T, PT]) Count(opts ...ListOpt) (count int, err error) {
+	db, err := r.query(opts, false)
+	if nil != err {
+		return 0, err
+	}
+
+	err = db.Model(PT(new(T))).Count(&count).Error
+	return
+}
+
+//Create *
+func (r *Repository[T, PT]) Create(data PT) error {
+	return r.DB.Create(data).Error
+}
+
+//Update 乐观锁更新, fields 为空时更新所有非零值字段, 否则只更新 fields 中的字段(允许零值)
+//cas_column 不一致时返回 *ConflictError
+func (r *Repository[T, PT]) Update(data PT, fields ...string) error {
+	model := data.GetModel()
+	if len(strings.TrimSpace(model.ID)) == 0 {
+		return ErrMissingID
+	}
+
+	var attrs map[string]interface{}
+	if len(fields) != 0 {
+		var err error
+		if attrs, err = FieldMask(r.DB, data, fields...); nil != err {
+			return err
+		}
+	}
+
+	return UpdateCAS(r.DB, data, &model.MemcachedCasColumn, attrs)
+}
+
+//Delete 软删除
+func (r *Repository[T, PT]) Delete(id string) error {
+	id = strings.TrimSpace(id)
+	if len(id) == 0 {
+		return ErrMissingID
+	}
+	data := PT(new(T))
+	data.GetModel().ID = id
+	return r.DB.Delete(data).Error
+}
+
+//Restore 恢复软删除
+func (r *Repository[T, PT]) Restore(id string) error {
+	id = strings.TrimSpace(id)
+	if len(id) == 0 {
+		return ErrMissingID
+	}
+	data := PT(new(T))
+	data.GetModel().ID = id
+	return r.DB.Unscoped().Model(data).Update("deleted_at", gorm.Expr("NULL")).Error
+}
+
+func (r *Repository[T, PT]) query(opts []ListOpt, paging bool) (*gorm.DB, error) {
+	db := r.DB
+
+	var opt ListOpt
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
+	if opt.WithDeleted {
+		db = db.Unscoped()
+	}
+
+	sql, args, err := And(opt.Filters...).Build()
+	if nil != err {
+		return nil, err
+	}
+	if len(sql) != 0 {
+		db = db.Where(sql, args...)
+	}
+
+	db = db.Scopes(opt.Scopes...)
+
+	if !paging {
+		return db, nil
+	}
+
+	for _, s := range opt.Sorts {
+		order, err := s.SQL()
+		if nil != err {
+			return nil, err
+		}
+		db = db.Order(order)
+	}
+
+	if opt.PageSize > 0 {
+		db = db.Offset(ComputeOffset(opt.PageNumber, opt.PageSize)).Limit(opt.PageSize)
+	}
+
+	return db, nil
+}
+
+//SQL 排序语句
+func (s Sort) SQL() (string, error) {
+	column := strings.TrimSpace(s.Column)
+	if !ValidColumn(column) {
+		return "", fmt.Errorf("Invalid sort column: %s", s.Column)
+	}
+	if s.Desc {
+		return column + " DESC", nil
+	}
+	return column + " ASC", nil
+}
+
+//ValidColumn 校验列名, 防止注入
+func ValidColumn(column string) bool {
+	return columnRegexp.MatchString(column)
+}
+
+//FieldMask 根据字段名或列名生成更新 map
+func FieldMask(db *gorm.DB, data interface{}, fields ...string) (map[string]interface{}, error) {
+	scope := db.NewScope(data)
+
+	attrs := make(map[string]interface{})
+	for _, name := range fields {
+		name = strings.TrimSpace(name)
+		field, ok := scope.FieldByName(name)
+		if !ok || field.IsIgnored || field.IsPrimaryKey {
+			return nil, fmt.Errorf("Invalid update field: %s", name)
+		}
+		attrs[field.DBName] = field.Field.Interface()
+	}
+	return attrs, nil
+}
@@ -0,0 +1,208 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+//ErrTreeCycle 移动后会形成环(移动到自身或子孙节点下)
+var ErrTreeCycle = errors.New("Cannot move a node under itself or its descendants.")
+
+//TreeModel 树形结构(物化路径), 与 Model 一起嵌入
+//Path 形如 "/rootID/parentID/ID/", 根节点 Depth 为 0
+type TreeModel struct {
+	ParentID string `sql:"index" gorm:"column:parent_id;type:varchar(100)" json:"parent_id,omitempty" xml:"parent_id,omitempty"`
+	Path     string `sql:"index" gorm:"column:path;type:varchar(700)" json:"path,omitempty" xml:"path,omitempty"`
+	Depth    int    `gorm:"column:depth;type:int(11)" json:"depth" xml:"depth"`
+}
+
+//GetTree *
+func (t *TreeModel) GetTree() *TreeModel {
+	return t
+}
+
+//AncestorIDs 祖先 ID, 从根节点开始, 不包含自身
+func (t *TreeModel) AncestorIDs() []string {
+	ids := strings.Split(strings.Trim(t.Path, "/"), "/")
+	if len(ids) <= 1 {
+		return []string{}
+	}
+	return ids[:len(ids)-1]
+}
+
+type treeRow struct {
+	ID   string `gorm:"column:id"`
+	Path string `gorm:"column:path"`
+}
+
+//TreeEntity 同时嵌入 Model 和 TreeModel 的实体约束
+type TreeEntity[T any] interface {
+	Entity[T]
+	GetTree() *TreeModel
+}
+
+//Tree 树形结构操作
+type Tree[T any, PT TreeEntity[T]] struct {
+	DB *gorm.DB
+}
+
+//NewTree *
+func NewTree[T any, PT TreeEntity[T]](db *gorm.DB) *Tree[T, PT] {
+	return &Tree[T, PT]{DB: db}
+}
+
+//Create 创建节点, 根据 ParentID 生成 Path 和 Depth
+func (t *Tree[T, PT]) Create(data PT) error {
+	return Transaction(t.DB, func(tx *gorm.DB) error {
+		node := data.GetTree()
+		node.ParentID = strings.TrimSpace(node.ParentID)
+
+		prefix, depth := "/", 0
+		if len(node.ParentID) != 0 {
+			parent := PT(new(T))
+			if err := tx.First(parent, "id = ?", node.ParentID).Error; nil != err {
+				return err
+			}
+			prefix, depth = parent.GetTree().Path, parent.GetTree().Depth+1
+		}
+
+		if err := tx.Create(data).Error; nil != err {
+			return err
+		}
+
+		node.Path, node.Depth = prefix+data.GetModel().ID+"/", depth
+		return tx.Model(data).UpdateColumns(map[string]interface{}{
+			"path":  node.Path,
+			"depth": node.Depth,
+		}).Error
+	})
+}
+
+//Roots 根节点
+func (t *Tree[T, PT]) Roots(opts ...ListOpt) ([]T, error) {
+	return t.list(Or(Eq("parent_id", ""), rawFilter{sql: "parent_id IS NULL"}), opts)
+}
+
+//Children 直接子节点
+func (t *Tree[T, PT]) Children(id string, opts ...ListOpt) ([]T, error) {
+	return t.list(Eq("parent_id", strings.TrimSpace(id)), opts)
+}
+
+//Ancestors 祖先节点, 从根节点开始, 不包含自身
+func (t *Tree[T, PT]) Ancestors(id string) ([]T, error) {
+	node, err := t.get(t.DB, id)
+	if nil != err {
+		return nil, err
+	}
+
+	data := make([]T, 0)
+	ids := node.GetTree().AncestorIDs()
+	if len(ids) == 0 {
+		return data, nil
+	}
+
+	err = t.DB.Where("id IN (?)", ids).Order("depth ASC").Find(&data).Error
+	return data, err
+}
+
+//Subtree 子树, 包含自身, 按层级和 sort_number 排序
+func (t *Tree[T, PT]) Subtree(id string) ([]T, error) {
+	node, err := t.get(t.DB, id)
+	if nil != err {
+		return nil, err
+	}
+
+	return t.list(Prefix("path", node.GetTree().Path), []ListOpt{{
+		Sorts: []Sort{{Column: "depth"}, {Column: "sort_number"}},
+	}})
+}
+
+//Move 将 id 及其子树移动到 parentID 下, parentID 为空时移动为根节点
+func (t *Tree[T, PT]) Move(id, parentID string) error {
+	parentID = strings.TrimSpace(parentID)
+
+	return Transaction(t.DB, func(tx *gorm.DB) error {
+		node, err := t.get(tx, id)
+		if nil != err {
+			return err
+		}
+		current := node.GetTree()
+
+		if current.ParentID == parentID {
+			return nil
+		}
+
+		prefix, depth := "/", 0
+		if len(parentID) != 0 {
+			parent, err := t.get(tx, parentID)
+			if nil != err {
+				return err
+			}
+			if strings.HasPrefix(parent.GetTree().Path, current.Path) {
+				return ErrTreeCycle
+			}
+			prefix, depth = parent.GetTree().Path, parent.GetTree().Depth+1
+		}
+
+		path := prefix + node.GetModel().ID + "/"
+
+		sql, args, err := Prefix("path", current.Path).Build()
+		if nil != err {
+			return err
+		}
+
+		rows := make([]treeRow, 0)
+		if err = tx.Model(PT(new(T))).Where(sql, args...).
+			Set("gorm:query_option", "FOR UPDATE").
+			Select("id, path").
+			Scan(&rows).Error; nil != err {
+			return err
+		}
+
+		//通过模型 scope 一次更新子树, 缓存按 ID 失效, 审计日志批量写入
+		var buf bytes.Buffer
+		caseArgs := make([]interface{}, 0, len(rows)*2)
+		ids := make([]string, len(rows))
+		for i, row := range rows {
+			buf.WriteString(" WHEN ? THEN ?")
+			caseArgs = append(caseArgs, row.ID, path+row.Path[len(current.Path):])
+			ids[i] = row.ID
+		}
+
+		return auditBatch(tx, PT(new(T)), ids, func() error {
+			return tx.Model(PT(new(T))).Where("id IN (?)", ids).UpdateColumns(map[string]interface{}{
+				"path":      gorm.Expr("CASE id"+buf.String()+" END", caseArgs...),
+				"depth":     gorm.Expr("depth + ?", depth-current.Depth),
+				"parent_id": gorm.Expr("CASE id WHEN ? THEN ? ELSE parent_id END", node.GetModel().ID, parentID),
+				CasColumn:   gorm.Expr("COALESCE("+CasColumn+", 0) + ?", 1),
+			}).Error
+		})
+	})
+}
+
+func (t *Tree[T, PT]) get(db *gorm.DB, id string) (PT, error) {
+	id = strings.TrimSpace(id)
+	if len(id) == 0 {
+		return nil, ErrMissingID
+	}
+	data := PT(new(T))
+	if err := db.First(data, "id = ?", id).Error; nil != err {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (t *Tree[T, PT]) list(filter Filter, opts []ListOpt) ([]T, error) {
+	var opt ListOpt
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	if len(opt.Sorts) == 0 {
+		opt.Sorts = []Sort{{Column: "sort_number"}}
+	}
+	opt.Filters = append([]Filter{filter}, opt.Filters...)
+	return NewRepository[T, PT](t.DB).List(opt)
+}
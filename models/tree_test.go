@@ -0,0 +1,71 @@
+package models
+
+import (
+	"testing"
+)
+
+type testNode struct {
+	Model
+	TreeModel
+}
+
+func (testNode) TableName() string {
+	return "test_node"
+}
+
+func TestTreeMove(t *testing.T) {
+	db := openTestDB(t, &testNode{})
+	if err := RegisterAudit(db, &testNode{}); nil != err {
+		t.Fatalf("RegisterAudit() error = %v", err)
+	}
+	tree := NewTree[testNode](db)
+
+	//a -> b -> c, d
+	nodes := make(map[string]*testNode)
+	for _, n := range [][2]string{{"a", ""}, {"b", "a"}, {"c", "b"}, {"d", ""}} {
+		node := &testNode{Model: Model{Name: n[0]}}
+		if len(n[1]) != 0 {
+			node.ParentID = nodes[n[1]].ID
+		}
+		if err := tree.Create(node); nil != err {
+			t.Fatalf("Create(%s) error = %v", n[0], err)
+		}
+		nodes[n[0]] = node
+	}
+	if err := db.Delete(&AuditLog{}).Error; nil != err {
+		t.Fatal(err)
+	}
+
+	if err := tree.Move(nodes["b"].ID, nodes["d"].ID); nil != err {
+		t.Fatalf("Move() error = %v", err)
+	}
+
+	want := map[string]struct {
+		parent string
+		path   string
+		depth  int
+	}{
+		"a": {"", "/" + nodes["a"].ID + "/", 0},
+		"b": {nodes["d"].ID, "/" + nodes["d"].ID + "/" + nodes["b"].ID + "/", 1},
+		"c": {nodes["b"].ID, "/" + nodes["d"].ID + "/" + nodes["b"].ID + "/" + nodes["c"].ID + "/", 2},
+	}
+	for name, w := range want {
+		node, err := tree.get(db, nodes[name].ID)
+		if nil != err {
+			t.Fatal(err)
+		}
+		if node.ParentID != w.parent || node.Path != w.path || node.Depth != w.depth {
+			t.Errorf("%s = %+v, want %+v", name, node.TreeModel, w)
+		}
+	}
+
+	count := 0
+	db.Model(&AuditLog{}).Where("action = ?", AuditUpdate).Count(&count)
+	if count != 2 {
+		t.Errorf("audit logs = %d, want 2", count)
+	}
+
+	if err := tree.Move(nodes["d"].ID, nodes["c"].ID); err != ErrTreeCycle {
+		t.Errorf("Move() under descendant error = %v, want ErrTreeCycle", err)
+	}
+}
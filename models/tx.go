@@ -0,0 +1,66 @@
+package models
+
+import (
+	"database/sql"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	txCallbacks = make(map[*sql.Tx][]func())
+	txMutex     sync.Mutex
+)
+
+//Transaction 同 db.Transaction, 提交成功后执行事务中 AfterCommit 注册的函数, 回滚时丢弃
+//db 已在事务中时直接在该事务中执行
+func Transaction(db *gorm.DB, fn func(tx *gorm.DB) error) (err error) {
+	if _, ok := db.CommonDB().(*sql.Tx); ok {
+		return fn(db)
+	}
+
+	var sqlTx *sql.Tx
+	defer func() {
+		if nil == sqlTx {
+			return
+		}
+		txMutex.Lock()
+		callbacks := txCallbacks[sqlTx]
+		delete(txCallbacks, sqlTx)
+		txMutex.Unlock()
+
+		if nil == err {
+			for _, callback := range callbacks {
+				callback()
+			}
+		}
+	}()
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if t, ok := tx.CommonDB().(*sql.Tx); ok {
+			sqlTx = t
+			txMutex.Lock()
+			txCallbacks[sqlTx] = make([]func(), 0)
+			txMutex.Unlock()
+		}
+		return fn(tx)
+	})
+}
+
+//AfterCommit db 为 Transaction 开启的事务时, 在提交成功后执行 fn, 返回 true;
+//不在事务中时立即执行 fn, 返回 true; 在其他方式开启的事务中时不执行, 返回 false
+func AfterCommit(db gorm.SQLCommon, fn func()) bool {
+	sqlTx, ok := db.(*sql.Tx)
+	if !ok {
+		fn()
+		return true
+	}
+
+	txMutex.Lock()
+	callbacks, ok := txCallbacks[sqlTx]
+	if ok {
+		txCallbacks[sqlTx] = append(callbacks, fn)
+	}
+	txMutex.Unlock()
+	return ok
+}
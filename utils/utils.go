@@ -123,27 +123,37 @@ func ArrayPath(path ...string) string {
 
 //CreateTLSConfig *
 func CreateTLSConfig(certFile, keyFile, caFile string, verifySSL bool) (t *tls.Config) {
-	if certFile != "" && keyFile != "" && caFile != "" {
-		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
-		if err != nil {
-			log.Fatal(err)
-		}
+	t, err := LoadTLSConfig(certFile, keyFile, caFile, verifySSL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return t
+}
 
-		caCert, err := ioutil.ReadFile(caFile)
-		if err != nil {
-			log.Fatal(err)
-		}
+//LoadTLSConfig 加载 TLS 配置, 证书文件未配置时返回 nil
+func LoadTLSConfig(certFile, keyFile, caFile string, verifySSL bool) (*tls.Config, error) {
+	if certFile == "" || keyFile == "" || caFile == "" {
+		return nil, nil
+	}
 
-		caCertPool := x509.NewCertPool()
-		caCertPool.AppendCertsFromPEM(caCert)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
 
-		t = &tls.Config{
-			Certificates:       []tls.Certificate{cert},
-			RootCAs:            caCertPool,
-			InsecureSkipVerify: verifySSL,
-		}
+	caCert, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
 	}
-	return t
+
+	caCertPool := x509.NewCertPool()
+	caCertPool.AppendCertsFromPEM(caCert)
+
+	return &tls.Config{
+		Certificates:       []tls.Certificate{cert},
+		RootCAs:            caCertPool,
+		InsecureSkipVerify: verifySSL,
+	}, nil
 }
 
 //RegGob 将自定义 Struct 注册 Gob
@@ -213,11 +223,11 @@ func (s *StringUtils) Set(v string) {
 }
 
 func (s *StringUtils) Clear() {
-	*s = StringUtils(0x1E)
+	*s = StringUtils(rune(0x1E))
 }
 
 func (s StringUtils) Exist() bool {
-	return string(s) != string(0x1E)
+	return string(s) != string(rune(0x1E))
 }
 
 func (s StringUtils) Bool() (bool, error) {
@@ -425,6 +435,25 @@ func (s StringUtils) PinYin() map[string][]string {
 	return pinYinMap
 }
 
+//PinYinSpell 拼音全拼(无声调, 小写), 非汉字原样保留, 如: 北京 => beijing
+func (s StringUtils) PinYinSpell() string {
+	return pinYinJoin(s.String(), pinyin.Normal)
+}
+
+//PinYinInitials 拼音首字母(小写), 非汉字原样保留, 如: 北京 => bj
+func (s StringUtils) PinYinInitials() string {
+	return pinYinJoin(s.String(), pinyin.FirstLetter)
+}
+
+func pinYinJoin(source string, style int) string {
+	a := pinyin.NewArgs()
+	a.Style = style
+	a.Fallback = func(r rune, a pinyin.Args) []string {
+		return []string{string(r)}
+	}
+	return strings.ToLower(strings.Join(pinyin.LazyPinyin(source, a), ""))
+}
+
 func (s StringUtils) CleanUP() string {
 
 	source := s.String()